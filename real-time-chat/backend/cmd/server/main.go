@@ -1,7 +1,11 @@
 package main
 
 import (
+	"expvar"
 	"log"
+	"net/http"
+	"real-time-chat/internal/authz"
+	"real-time-chat/internal/backplane"
 	"real-time-chat/internal/config"
 	"real-time-chat/internal/database"
 	"real-time-chat/internal/handlers"
@@ -32,15 +36,54 @@ func main() {
 	userRepo := repository.NewUserRepository(db)
 	roomRepo := repository.NewRoomRepository(db)
 	messageRepo := repository.NewMessageRepository(db)
+	sessionRepo := repository.NewSessionRepository(db)
+	invitationRepo := repository.NewInvitationRepository(db)
+	mentionRepo := repository.NewMentionRepository(db)
+	connectionRepo := repository.NewConnectionRepository(db)
+
+	authorizer := authz.NewAuthorizer(roomRepo)
+
+	// Connect to the backplane shared with other server instances
+	var bp backplane.Backplane
+	switch cfg.Backplane {
+	case "":
+	case "postgres":
+		pg, err := backplane.NewPostgres(db, database.DSN(cfg))
+		if err != nil {
+			log.Fatalf("Failed to start backplane: %v", err)
+		}
+		defer pg.Close()
+		bp = pg
+		log.Println("Using PostgreSQL backplane")
+	case "redis":
+		rd, err := backplane.NewRedis(cfg.RedisAddr, cfg.RedisPassword)
+		if err != nil {
+			log.Fatalf("Failed to start backplane: %v", err)
+		}
+		defer rd.Close()
+		bp = rd
+		log.Printf("Using Redis backplane at %s", cfg.RedisAddr)
+	default:
+		log.Fatalf("Unknown backplane %q", cfg.Backplane)
+	}
 
 	// Initialize WebSocket hub
-	hub := websocket.NewHub(messageRepo, userRepo)
+	hub := websocket.NewHub(messageRepo, userRepo, connectionRepo, bp, websocket.SlowConsumerPolicy{
+		SendBuffer: cfg.SendBufferSize,
+		Backlog:    cfg.SlowClientBacklog,
+		Grace:      cfg.SlowClientGrace,
+	})
 	go hub.Run()
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(userRepo, cfg.JWTSecret)
-	roomHandler := handlers.NewRoomHandler(roomRepo, messageRepo)
-	wsHandler := handlers.NewWebSocketHandler(hub, messageRepo, roomRepo)
+	authHandler := handlers.NewAuthHandler(userRepo, sessionRepo, cfg.JWTSecret, cfg.AccessTokenTTL, cfg.RefreshTokenTTL)
+	roomHandler := handlers.NewRoomHandler(roomRepo, messageRepo, authorizer, hub)
+	messageHandler := handlers.NewMessageHandler(messageRepo, authorizer, hub)
+	dmHandler := handlers.NewDMHandler(roomRepo, userRepo, hub)
+	mentionHandler := handlers.NewMentionHandler(mentionRepo)
+	presenceHandler := handlers.NewPresenceHandler(userRepo, connectionRepo, hub)
+	invitationHandler := handlers.NewInvitationHandler(invitationRepo, roomRepo, userRepo, authorizer, hub)
+	wsHandler := handlers.NewWebSocketHandler(hub, messageRepo, roomRepo, mentionRepo, authorizer)
 
 	// Setup Gin router
 	router := gin.Default()
@@ -59,28 +102,69 @@ func main() {
 	{
 		api.POST("/register", authHandler.Register)
 		api.POST("/login", authHandler.Login)
+		api.POST("/token/refresh", authHandler.RefreshToken)
 	}
 
 	// Protected routes
 	protected := api.Group("")
-	protected.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+	protected.Use(middleware.AuthMiddleware(cfg.JWTSecret, sessionRepo))
 	{
 		// User routes
 		protected.GET("/me", authHandler.GetCurrentUser)
+		protected.POST("/logout", authHandler.Logout)
+		protected.GET("/me/devices", presenceHandler.GetDevices)
+		protected.GET("/me/status", presenceHandler.GetStatus)
+		protected.PUT("/me/status", presenceHandler.SetStatus)
 
 		// Room routes
 		protected.GET("/rooms", roomHandler.GetRooms)
 		protected.POST("/rooms", roomHandler.CreateRoom)
 		protected.GET("/rooms/my", roomHandler.GetUserRooms)
 		protected.GET("/rooms/:id", roomHandler.GetRoom)
+		protected.PATCH("/rooms/:id", roomHandler.UpdateRoom)
+		protected.DELETE("/rooms/:id", roomHandler.DeleteRoom)
 		protected.POST("/rooms/:id/join", roomHandler.JoinRoom)
 		protected.POST("/rooms/:id/leave", roomHandler.LeaveRoom)
 		protected.GET("/rooms/:id/members", roomHandler.GetRoomMembers)
 		protected.GET("/rooms/:id/messages", roomHandler.GetRoomMessages)
+		protected.PUT("/rooms/:id/members/:userId/role", roomHandler.UpdateMemberRole)
+		protected.DELETE("/rooms/:id/members/:userId", roomHandler.KickMember)
+		protected.POST("/rooms/:id/transfer", roomHandler.TransferOwnership)
+
+		// Direct message routes
+		protected.POST("/dms", dmHandler.CreateDM)
+		protected.GET("/dms", dmHandler.GetDMs)
+
+		// Message routes
+		protected.PATCH("/messages/:id", messageHandler.EditMessage)
+		protected.DELETE("/messages/:id", messageHandler.DeleteMessage)
+		protected.GET("/messages/:id/history", messageHandler.GetMessageHistory)
+		protected.GET("/messages/:id/thread", messageHandler.GetThread)
+		protected.GET("/mentions", mentionHandler.GetMentions)
+
+		// Invitation routes
+		protected.POST("/rooms/:id/invitations", invitationHandler.InviteUser)
+		protected.GET("/rooms/:id/invitations", invitationHandler.GetRoomInvitations)
+		protected.POST("/rooms/:id/invite-links", invitationHandler.CreateInviteLink)
+		protected.GET("/invitations", invitationHandler.GetMyInvitations)
+		protected.POST("/invitations/:id/accept", invitationHandler.AcceptInvitation)
+		protected.POST("/invitations/:id/decline", invitationHandler.DeclineInvitation)
+		protected.DELETE("/invitations/:id", invitationHandler.RevokeInvitation)
+		protected.POST("/invite-links/:token/accept", invitationHandler.RedeemInviteLink)
 	}
 
 	// WebSocket route (with auth)
-	router.GET("/ws", middleware.AuthMiddleware(cfg.JWTSecret), wsHandler.HandleWebSocket)
+	router.GET("/ws", middleware.AuthMiddleware(cfg.JWTSecret, sessionRepo), wsHandler.HandleWebSocket)
+
+	// Runtime counters, including dropped WebSocket frames, are served on a
+	// separate listener that is not meant to be reachable from outside
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		if err := http.ListenAndServe(cfg.DebugAddr, mux); err != nil {
+			log.Printf("Debug listener stopped: %v", err)
+		}
+	}()
 
 	log.Printf("Server starting on port %s", cfg.ServerPort)
 	if err := router.Run(":" + cfg.ServerPort); err != nil {
@@ -0,0 +1,108 @@
+package authz
+
+import (
+	"database/sql"
+	"errors"
+	"real-time-chat/internal/models"
+	"real-time-chat/internal/repository"
+)
+
+var (
+	ErrRoomNotFound = errors.New("room not found")
+	ErrNotMember    = errors.New("not a member of this room")
+	ErrInviteOnly   = errors.New("room is private and requires an invitation")
+)
+
+// Authorizer is the single place that decides who may see or act on a room.
+// Both the REST handlers and the WebSocket client go through it so the two
+// transports cannot drift apart.
+type Authorizer struct {
+	roomRepo *repository.RoomRepository
+}
+
+func NewAuthorizer(roomRepo *repository.RoomRepository) *Authorizer {
+	return &Authorizer{roomRepo: roomRepo}
+}
+
+// CanView allows anyone to look at a public room, but only members to look
+// inside a private one.
+func (a *Authorizer) CanView(userID, roomID int) (*models.Room, error) {
+	room, err := a.getRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !room.IsPrivate {
+		return room, nil
+	}
+
+	if err := a.requireMember(roomID, userID); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// CanJoin allows joining public rooms freely. Private rooms can only be
+// entered by users who already hold a membership, which is granted through
+// an invitation.
+func (a *Authorizer) CanJoin(userID, roomID int) (*models.Room, error) {
+	room, err := a.getRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !room.IsPrivate {
+		return room, nil
+	}
+
+	if err := a.requireMember(roomID, userID); err != nil {
+		if errors.Is(err, ErrNotMember) {
+			return nil, ErrInviteOnly
+		}
+		return nil, err
+	}
+	return room, nil
+}
+
+// CanPost requires membership regardless of the room's visibility.
+func (a *Authorizer) CanPost(userID, roomID int) error {
+	_, err := a.Require(userID, roomID, PermPost)
+	return err
+}
+
+// CanInvite lets any member bring others into the room.
+func (a *Authorizer) CanInvite(userID, roomID int) (*models.Room, error) {
+	room, err := a.getRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	// DMs are defined by their participant set; start a new DM instead
+	if room.Type == models.RoomTypeDM {
+		return nil, ErrForbidden
+	}
+
+	if _, err := a.Require(userID, roomID, PermInvite); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+func (a *Authorizer) getRoom(roomID int) (*models.Room, error) {
+	room, err := a.roomRepo.GetByID(roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRoomNotFound
+	}
+	return room, err
+}
+
+func (a *Authorizer) requireMember(roomID, userID int) error {
+	isMember, err := a.roomRepo.IsMember(roomID, userID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return ErrNotMember
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package authz
+
+import (
+	"database/sql"
+	"errors"
+	"real-time-chat/internal/models"
+)
+
+var (
+	ErrForbidden = errors.New("insufficient room permissions")
+)
+
+type Permission string
+
+const (
+	PermPost              Permission = "post"
+	PermInvite            Permission = "invite"
+	PermManageInvitations Permission = "manage_invitations"
+	PermDeleteAnyMessage  Permission = "delete_any_message"
+	PermViewEditHistory   Permission = "view_edit_history"
+	PermKickMember        Permission = "kick_member"
+	PermManageRoles       Permission = "manage_roles"
+	PermEditRoom          Permission = "edit_room"
+	PermDeleteRoom        Permission = "delete_room"
+	PermTransferOwnership Permission = "transfer_ownership"
+)
+
+// roleRank orders roles so that a higher rank implies every permission of
+// the ranks below it.
+var roleRank = map[string]int{
+	models.RoleMember:    1,
+	models.RoleModerator: 2,
+	models.RoleAdmin:     3,
+	models.RoleOwner:     4,
+}
+
+// permissionMatrix holds the lowest role that is granted each permission.
+var permissionMatrix = map[Permission]string{
+	PermPost:              models.RoleMember,
+	PermInvite:            models.RoleMember,
+	PermManageInvitations: models.RoleModerator,
+	PermDeleteAnyMessage:  models.RoleModerator,
+	PermViewEditHistory:   models.RoleModerator,
+	PermKickMember:        models.RoleModerator,
+	PermManageRoles:       models.RoleAdmin,
+	PermEditRoom:          models.RoleOwner,
+	PermDeleteRoom:        models.RoleOwner,
+	PermTransferOwnership: models.RoleOwner,
+}
+
+func IsValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// Outranks reports whether role a sits strictly above role b.
+func Outranks(a, b string) bool {
+	return roleRank[a] > roleRank[b]
+}
+
+func HasPermission(role string, perm Permission) bool {
+	minRole, ok := permissionMatrix[perm]
+	if !ok {
+		return false
+	}
+	return roleRank[role] >= roleRank[minRole]
+}
+
+// Require checks the user's role in the room against the permission matrix
+// and returns that role so callers can make rank comparisons.
+func (a *Authorizer) Require(userID, roomID int, perm Permission) (string, error) {
+	if _, err := a.getRoom(roomID); err != nil {
+		return "", err
+	}
+
+	role, err := a.Role(userID, roomID)
+	if err != nil {
+		return "", err
+	}
+
+	if !HasPermission(role, perm) {
+		return role, ErrForbidden
+	}
+	return role, nil
+}
+
+// Role returns the user's role in the room, or ErrNotMember.
+func (a *Authorizer) Role(userID, roomID int) (string, error) {
+	role, err := a.roomRepo.GetMemberRole(roomID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotMember
+	}
+	return role, err
+}
+
+// CanActOn checks that the actor holds perm in the room and outranks the
+// target member, so moderators cannot kick admins and nobody can act on the
+// owner.
+func (a *Authorizer) CanActOn(actorID, targetID, roomID int, perm Permission) (actorRole, targetRole string, err error) {
+	actorRole, err = a.Require(actorID, roomID, perm)
+	if err != nil {
+		return "", "", err
+	}
+
+	targetRole, err = a.Role(targetID, roomID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !Outranks(actorRole, targetRole) {
+		return "", "", ErrForbidden
+	}
+	return actorRole, targetRole, nil
+}
+
+// CanEditMessage only lets authors edit their own messages, and only while
+// they are still allowed to post in the room.
+func (a *Authorizer) CanEditMessage(userID int, message *models.Message) error {
+	if message.IsDeleted || message.UserID != userID {
+		return ErrForbidden
+	}
+	return a.CanPost(userID, message.RoomID)
+}
+
+// CanDeleteMessage lets authors remove their own messages and moderators
+// remove those of members they outrank. Messages of authors who have since
+// left the room can be removed by any moderator.
+func (a *Authorizer) CanDeleteMessage(userID int, message *models.Message) error {
+	if message.UserID == userID {
+		return a.CanPost(userID, message.RoomID)
+	}
+
+	role, err := a.Require(userID, message.RoomID, PermDeleteAnyMessage)
+	if err != nil {
+		return err
+	}
+
+	authorRole, err := a.Role(message.UserID, message.RoomID)
+	if err != nil && !errors.Is(err, ErrNotMember) {
+		return err
+	}
+	if !canDeleteMessageOf(role, authorRole) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// canDeleteMessageOf reports whether a member with role may delete a message
+// by an author with authorRole, which is empty for former members.
+func canDeleteMessageOf(role, authorRole string) bool {
+	return HasPermission(role, PermDeleteAnyMessage) && Outranks(role, authorRole)
+}
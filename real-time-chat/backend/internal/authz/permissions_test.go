@@ -0,0 +1,135 @@
+package authz
+
+import (
+	"errors"
+	"real-time-chat/internal/models"
+	"testing"
+)
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{models.RoleMember, PermPost, true},
+		{models.RoleMember, PermInvite, true},
+		{models.RoleMember, PermKickMember, false},
+		{models.RoleMember, PermDeleteAnyMessage, false},
+		{models.RoleModerator, PermKickMember, true},
+		{models.RoleModerator, PermViewEditHistory, true},
+		{models.RoleModerator, PermManageRoles, false},
+		{models.RoleAdmin, PermManageRoles, true},
+		{models.RoleAdmin, PermEditRoom, false},
+		{models.RoleAdmin, PermDeleteRoom, false},
+		{models.RoleOwner, PermDeleteRoom, true},
+		{models.RoleOwner, PermTransferOwnership, true},
+		{models.RoleOwner, PermPost, true},
+		{"", PermPost, false},
+		{"superuser", PermPost, false},
+		{models.RoleOwner, Permission("unknown"), false},
+	}
+
+	for _, tt := range tests {
+		if got := HasPermission(tt.role, tt.perm); got != tt.want {
+			t.Errorf("HasPermission(%q, %q) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+}
+
+// Every permission must name a real role, or nobody could ever hold it.
+func TestPermissionMatrixRoles(t *testing.T) {
+	for perm, role := range permissionMatrix {
+		if !IsValidRole(role) {
+			t.Errorf("permission %q requires unknown role %q", perm, role)
+		}
+	}
+}
+
+func TestOutranks(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{models.RoleOwner, models.RoleAdmin, true},
+		{models.RoleAdmin, models.RoleModerator, true},
+		{models.RoleModerator, models.RoleMember, true},
+		{models.RoleOwner, models.RoleMember, true},
+		{models.RoleAdmin, models.RoleAdmin, false},
+		{models.RoleModerator, models.RoleAdmin, false},
+		{models.RoleMember, models.RoleOwner, false},
+		{models.RoleMember, "", true},
+		{"", models.RoleMember, false},
+	}
+
+	for _, tt := range tests {
+		if got := Outranks(tt.a, tt.b); got != tt.want {
+			t.Errorf("Outranks(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestIsValidRole(t *testing.T) {
+	tests := []struct {
+		role string
+		want bool
+	}{
+		{models.RoleOwner, true},
+		{models.RoleAdmin, true},
+		{models.RoleModerator, true},
+		{models.RoleMember, true},
+		{"", false},
+		{"Owner", false},
+		{"guest", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsValidRole(tt.role); got != tt.want {
+			t.Errorf("IsValidRole(%q) = %v, want %v", tt.role, got, tt.want)
+		}
+	}
+}
+
+// The ownership and tombstone checks come before any lookup, so they need
+// no database.
+func TestCanEditMessageRejectsBeforeLookup(t *testing.T) {
+	a := NewAuthorizer(nil)
+
+	tests := []struct {
+		name    string
+		message *models.Message
+	}{
+		{"someone else's message", &models.Message{UserID: 2, RoomID: 1}},
+		{"deleted message", &models.Message{UserID: 1, RoomID: 1, IsDeleted: true}},
+	}
+
+	for _, tt := range tests {
+		if err := a.CanEditMessage(1, tt.message); !errors.Is(err, ErrForbidden) {
+			t.Errorf("%s: got %v, want ErrForbidden", tt.name, err)
+		}
+	}
+}
+
+func TestCanDeleteMessageOf(t *testing.T) {
+	tests := []struct {
+		role, authorRole string
+		want             bool
+	}{
+		{models.RoleModerator, models.RoleMember, true},
+		{models.RoleModerator, "", true},
+		{models.RoleModerator, models.RoleModerator, false},
+		{models.RoleModerator, models.RoleAdmin, false},
+		{models.RoleModerator, models.RoleOwner, false},
+		{models.RoleAdmin, models.RoleModerator, true},
+		{models.RoleAdmin, models.RoleOwner, false},
+		{models.RoleOwner, models.RoleAdmin, true},
+		{models.RoleMember, "", false},
+		{models.RoleMember, models.RoleMember, false},
+	}
+
+	for _, tt := range tests {
+		if got := canDeleteMessageOf(tt.role, tt.authorRole); got != tt.want {
+			t.Errorf("canDeleteMessageOf(%q, %q) = %v, want %v", tt.role, tt.authorRole, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Package backplane relays hub events between server instances so clients
+// connected to different replicas see the same rooms.
+package backplane
+
+import "encoding/json"
+
+// Event kinds. Each names the hub operation the receiving instance repeats
+// for its own connections.
+const (
+	// KindRoom delivers Frame to the clients in RoomID, except those of
+	// ExcludeUserID.
+	KindRoom = "room"
+	// KindUser delivers Frame to every connection of UserID.
+	KindUser = "user"
+	// KindAll delivers Frame to every connected client.
+	KindAll = "all"
+	// KindSubscribe attaches the connections of UserID to RoomID.
+	KindSubscribe = "subscribe"
+	// KindUnsubscribe detaches the connections of UserID from RoomID.
+	KindUnsubscribe = "unsubscribe"
+	// KindCloseRoom delivers Frame to RoomID and then drops the room.
+	KindCloseRoom = "close_room"
+	// KindResync is never published. A backplane delivers it to its own
+	// subscriber when events may have been lost, for instance while it
+	// reconnected, so the hub can tell clients to refetch their rooms.
+	KindResync = "resync"
+)
+
+// Event is a hub operation published by one instance for all the others.
+type Event struct {
+	// Origin identifies the publishing instance, which has already applied
+	// the event locally.
+	Origin        string          `json:"origin"`
+	Kind          string          `json:"kind"`
+	RoomID        int             `json:"room_id,omitempty"`
+	UserID        int             `json:"user_id,omitempty"`
+	ExcludeUserID int             `json:"exclude_user_id,omitempty"`
+	Type          string          `json:"type,omitempty"`
+	Frame         json.RawMessage `json:"frame,omitempty"`
+
+	// BinaryFrame is Frame encoded as MessagePack, so instances can pass it
+	// to binary clients without transcoding.
+	BinaryFrame []byte `json:"binary_frame,omitempty"`
+}
+
+// Backplane is a pub/sub transport shared by every server instance.
+type Backplane interface {
+	// Publish sends an event to every subscribed instance, including the
+	// publisher's own.
+	Publish(event *Event) error
+
+	// Subscribe starts delivering published events to handler, one at a
+	// time and in the order received. It must be called once. Whenever
+	// events may have been missed, handler receives a KindResync event.
+	Subscribe(handler func(*Event)) error
+
+	Close() error
+}
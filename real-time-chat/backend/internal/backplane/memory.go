@@ -0,0 +1,91 @@
+package backplane
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Memory is an in-process backplane. Unlike the network backplanes, one
+// Memory may be shared by several hubs in the same process, each standing
+// in for a server instance, so multi-instance behaviour can be exercised
+// on a single machine.
+type Memory struct {
+	mu          sync.Mutex
+	subscribers []chan *Event
+	closed      bool
+	done        chan struct{}
+}
+
+// memoryQueueSize bounds the events waiting for a slow subscriber before
+// Publish blocks.
+const memoryQueueSize = 1024
+
+func NewMemory() *Memory {
+	return &Memory{done: make(chan struct{})}
+}
+
+// Publish hands each subscriber its own copy of the event, as if it had
+// crossed the network. It waits for a slow subscriber rather than drop the
+// event, but without holding the lock, so other publishers and Close are
+// not held up.
+func (m *Memory) Publish(event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	subscribers := m.subscribers
+	m.mu.Unlock()
+
+	for _, ch := range subscribers {
+		var copied Event
+		if err := json.Unmarshal(data, &copied); err != nil {
+			return err
+		}
+		select {
+		case ch <- &copied:
+		case <-m.done:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Subscribe may be called once per hub sharing the backplane.
+func (m *Memory) Subscribe(handler func(*Event)) error {
+	ch := make(chan *Event, memoryQueueSize)
+
+	m.mu.Lock()
+	m.subscribers = append(m.subscribers, ch)
+	m.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case event := <-ch:
+				handler(event)
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops delivery. Subscriber channels are never closed, so a Publish
+// still sending cannot panic.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.closed {
+		m.closed = true
+		close(m.done)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package backplane
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryDeliversToEverySubscriber(t *testing.T) {
+	m := NewMemory()
+	defer m.Close()
+
+	received := make([]chan *Event, 2)
+	for i := range received {
+		ch := make(chan *Event, 1)
+		received[i] = ch
+		m.Subscribe(func(e *Event) { ch <- e })
+	}
+
+	event := &Event{Origin: "a", Kind: KindRoom, RoomID: 3, Type: "new_message", Frame: []byte(`{"type":"new_message"}`)}
+	if err := m.Publish(event); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ch := range received {
+		select {
+		case got := <-ch:
+			if got == event || got.RoomID != 3 || string(got.Frame) != string(event.Frame) {
+				t.Errorf("subscriber %d got %+v, want a copy of %+v", i, got, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("subscriber %d got nothing", i)
+		}
+	}
+}
+
+// A subscriber that stops reading must hold up neither Close nor a Publish
+// waiting on it.
+func TestMemoryCloseReleasesBlockedPublish(t *testing.T) {
+	m := NewMemory()
+	block := make(chan struct{})
+	defer close(block)
+	var handled atomic.Int32
+	m.Subscribe(func(*Event) {
+		handled.Add(1)
+		<-block
+	})
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < memoryQueueSize+2; i++ {
+			m.Publish(&Event{Kind: KindAll})
+		}
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("Publish did not wait for the stalled subscriber")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		m.Close()
+		close(closed)
+	}()
+	for _, ch := range []chan struct{}{closed, published} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("Close blocked behind a stalled subscriber")
+		}
+	}
+	if n := handled.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+}
@@ -0,0 +1,148 @@
+package backplane
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	pgChannel = "hub_events"
+
+	// maxNotifyPayload keeps NOTIFY payloads under PostgreSQL's 8000 byte
+	// limit. Larger events are stored in hub_events and only their ID is
+	// sent.
+	maxNotifyPayload = 7900
+
+	// spillPrefix marks a payload that names a hub_events row.
+	spillPrefix = "@"
+
+	// spillRetention is how long stored events are kept for listeners
+	// that are slow to fetch them.
+	spillRetention = 5 * time.Minute
+)
+
+// Postgres relays events through LISTEN/NOTIFY on the application database.
+type Postgres struct {
+	db       *sql.DB
+	listener *pq.Listener
+	done     chan struct{}
+}
+
+// NewPostgres opens a dedicated listening connection using dsn. db is used
+// to publish.
+func NewPostgres(db *sql.DB, dsn string) (*Postgres, error) {
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Backplane listener error: %v", err)
+		}
+	})
+	if err := listener.Listen(pgChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &Postgres{
+		db:       db,
+		listener: listener,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (p *Postgres) Publish(event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	payload := string(data)
+	if len(payload) > maxNotifyPayload {
+		var id int64
+		err := p.db.QueryRow(`INSERT INTO hub_events (payload) VALUES ($1) RETURNING id`, payload).Scan(&id)
+		if err != nil {
+			return err
+		}
+		payload = spillPrefix + strconv.FormatInt(id, 10)
+	}
+
+	_, err = p.db.Exec(`SELECT pg_notify($1, $2)`, pgChannel, payload)
+	return err
+}
+
+func (p *Postgres) Subscribe(handler func(*Event)) error {
+	go p.listen(handler)
+	return nil
+}
+
+func (p *Postgres) listen(handler func(*Event)) {
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+	prune := time.NewTicker(time.Minute)
+	defer prune.Stop()
+
+	for {
+		select {
+		case n, ok := <-p.listener.Notify:
+			if !ok {
+				return
+			}
+			if n == nil {
+				// The listener reconnected; anything sent meanwhile is gone
+				log.Printf("Backplane reconnected, events may have been missed")
+				handler(&Event{Kind: KindResync})
+				continue
+			}
+
+			event, err := p.load(n.Extra)
+			if err != nil {
+				log.Printf("Error reading backplane event: %v", err)
+				continue
+			}
+			handler(event)
+
+		case <-ping.C:
+			go p.listener.Ping()
+
+		case <-prune.C:
+			if _, err := p.db.Exec(
+				`DELETE FROM hub_events WHERE created_at < NOW() - $1 * INTERVAL '1 second'`,
+				spillRetention.Seconds(),
+			); err != nil {
+				log.Printf("Error pruning backplane events: %v", err)
+			}
+
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// load decodes a notification payload, fetching it from hub_events when the
+// event was too large to send inline.
+func (p *Postgres) load(payload string) (*Event, error) {
+	if strings.HasPrefix(payload, spillPrefix) {
+		id, err := strconv.ParseInt(strings.TrimPrefix(payload, spillPrefix), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.db.QueryRow(`SELECT payload FROM hub_events WHERE id = $1`, id).Scan(&payload); err != nil {
+			return nil, err
+		}
+	}
+
+	var event Event
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (p *Postgres) Close() error {
+	close(p.done)
+	return p.listener.Close()
+}
@@ -0,0 +1,275 @@
+package backplane
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	redisChannel     = "hub_events"
+	redisDialTimeout = 5 * time.Second
+	redisIOTimeout   = 5 * time.Second
+	redisRetryDelay  = time.Second
+)
+
+// Redis relays events through Redis PUBLISH/SUBSCRIBE. It speaks just
+// enough of the RESP protocol for that and works with any Redis-compatible
+// server.
+type Redis struct {
+	addr     string
+	password string
+
+	// pub is the connection used to publish, dialled on demand
+	pubMu sync.Mutex
+	pub   *redisConn
+
+	mu     sync.Mutex
+	sub    *redisConn
+	closed bool
+}
+
+// NewRedis connects to the server at addr, checking it is reachable.
+// password may be empty.
+func NewRedis(addr, password string) (*Redis, error) {
+	r := &Redis{addr: addr, password: password}
+
+	conn, err := r.dial()
+	if err != nil {
+		return nil, err
+	}
+	r.pub = conn
+	return r, nil
+}
+
+func (r *Redis) Publish(event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	closed := r.closed
+	r.mu.Unlock()
+	if closed {
+		return nil
+	}
+
+	r.pubMu.Lock()
+	defer r.pubMu.Unlock()
+
+	// One retry on a fresh connection covers a server restart
+	for attempt := 0; ; attempt++ {
+		if r.pub == nil {
+			if r.pub, err = r.dial(); err != nil {
+				return err
+			}
+		}
+		if _, err = r.pub.do("PUBLISH", redisChannel, string(data)); err == nil {
+			return nil
+		}
+		r.pub.close()
+		r.pub = nil
+		if attempt > 0 {
+			return err
+		}
+	}
+}
+
+func (r *Redis) Subscribe(handler func(*Event)) error {
+	go r.listen(handler)
+	return nil
+}
+
+// listen holds a subscription open, reconnecting whenever it drops.
+func (r *Redis) listen(handler func(*Event)) {
+	for resync := false; ; resync = true {
+		conn, err := r.dial()
+		if err == nil {
+			err = r.receive(conn, handler, resync)
+		}
+
+		r.mu.Lock()
+		closed := r.closed
+		r.mu.Unlock()
+		if closed {
+			return
+		}
+
+		log.Printf("Backplane subscription lost, events may have been missed: %v", err)
+		time.Sleep(redisRetryDelay)
+	}
+}
+
+// receive reads events from a new subscription. resync is set when an
+// earlier subscription dropped, so events published meanwhile are gone.
+func (r *Redis) receive(conn *redisConn, handler func(*Event), resync bool) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		conn.close()
+		return nil
+	}
+	r.sub = conn
+	r.mu.Unlock()
+	defer conn.close()
+
+	if err := conn.write("SUBSCRIBE", redisChannel); err != nil {
+		return err
+	}
+	if resync {
+		handler(&Event{Kind: KindResync})
+	}
+
+	for {
+		// Subscribed connections stay idle between events
+		conn.conn.SetReadDeadline(time.Time{})
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+
+		// Pushes are ["message", channel, payload]; subscribe confirmations
+		// are skipped
+		push, ok := reply.([]interface{})
+		if !ok || len(push) != 3 || push[0] != "message" {
+			continue
+		}
+		payload, ok := push[2].(string)
+		if !ok {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			log.Printf("Error reading backplane event: %v", err)
+			continue
+		}
+		handler(&event)
+	}
+}
+
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	if r.sub != nil {
+		r.sub.close()
+	}
+	r.mu.Unlock()
+
+	r.pubMu.Lock()
+	defer r.pubMu.Unlock()
+	if r.pub != nil {
+		r.pub.close()
+		r.pub = nil
+	}
+	return nil
+}
+
+func (r *Redis) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", r.addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if r.password != "" {
+		if _, err := c.do("AUTH", r.password); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// redisConn is a single RESP connection.
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// do sends a command and waits for its reply. Error replies are returned
+// as errors.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(redisIOTimeout))
+	return c.read()
+}
+
+// write sends a command as an array of bulk strings.
+func (c *redisConn) write(args ...string) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(redisIOTimeout))
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// read parses one reply: simple and bulk strings become strings, integers
+// int64, arrays []interface{} and nil bulk strings or arrays nil.
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, errors.New("redis: " + body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+	}
+}
+
+func (c *redisConn) close() {
+	c.conn.Close()
+}
@@ -1,32 +1,80 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	DBHost     string
-	DBPort     string
-	DBUser     string
-	DBPassword string
-	DBName     string
-	JWTSecret  string
-	ServerPort string
+	DBHost          string
+	DBPort          string
+	DBUser          string
+	DBPassword      string
+	DBName          string
+	JWTSecret       string
+	ServerPort      string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	Backplane       string
+	RedisAddr       string
+	RedisPassword   string
+	DebugAddr       string
+
+	// Slow WebSocket consumers
+	SendBufferSize    int
+	SlowClientBacklog int
+	SlowClientGrace   time.Duration
 }
 
 func Load() (*Config, error) {
 	godotenv.Load()
 
+	accessTTL, err := getDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshTTL, err := getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	sendBuffer, err := getInt("SEND_BUFFER_SIZE", 256)
+	if err != nil {
+		return nil, err
+	}
+
+	slowBacklog, err := getInt("SLOW_CLIENT_BACKLOG", 1024)
+	if err != nil {
+		return nil, err
+	}
+
+	slowGrace, err := getDuration("SLOW_CLIENT_GRACE", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     getEnv("DB_PORT", "5432"),
-		DBUser:     getEnv("DB_USER", "postgres"),
-		DBPassword: getEnv("DB_PASSWORD", ""),
-		DBName:     getEnv("DB_NAME", "chat_db"),
-		JWTSecret:  getEnv("JWT_SECRET", "default-secret-key"),
-		ServerPort: getEnv("SERVER_PORT", "8080"),
+		DBHost:            getEnv("DB_HOST", "localhost"),
+		DBPort:            getEnv("DB_PORT", "5432"),
+		DBUser:            getEnv("DB_USER", "postgres"),
+		DBPassword:        getEnv("DB_PASSWORD", ""),
+		DBName:            getEnv("DB_NAME", "chat_db"),
+		JWTSecret:         getEnv("JWT_SECRET", "default-secret-key"),
+		ServerPort:        getEnv("SERVER_PORT", "8080"),
+		AccessTokenTTL:    accessTTL,
+		RefreshTokenTTL:   refreshTTL,
+		Backplane:         getEnv("BACKPLANE", ""),
+		RedisAddr:         getEnv("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:     getEnv("REDIS_PASSWORD", ""),
+		DebugAddr:         getEnv("DEBUG_ADDR", "localhost:6060"),
+		SendBufferSize:    sendBuffer,
+		SlowClientBacklog: slowBacklog,
+		SlowClientGrace:   slowGrace,
 	}, nil
 }
 
@@ -36,3 +84,29 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+func getInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s: %q", key, value)
+	}
+	return n, nil
+}
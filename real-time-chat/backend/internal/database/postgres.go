@@ -8,13 +8,16 @@ import (
 	_ "github.com/lib/pq"
 )
 
-func Connect(cfg *config.Config) (*sql.DB, error) {
-	dsn := fmt.Sprintf(
+// DSN returns the connection string for the configured database.
+func DSN(cfg *config.Config) string {
+	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName,
 	)
+}
 
-	db, err := sql.Open("postgres", dsn)
+func Connect(cfg *config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", DSN(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -65,8 +68,112 @@ func createTables(db *sql.DB) error {
 			message_type VARCHAR(20) DEFAULT 'text',
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id SERIAL PRIMARY KEY,
+			family_id VARCHAR(64) NOT NULL,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			rotated_at TIMESTAMP,
+			revoked_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS room_invitations (
+			id SERIAL PRIMARY KEY,
+			room_id INTEGER REFERENCES rooms(id) ON DELETE CASCADE,
+			inviter_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			invitee_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			token VARCHAR(64) UNIQUE,
+			max_uses INTEGER,
+			use_count INTEGER DEFAULT 0,
+			expires_at TIMESTAMP,
+			status VARCHAR(20) DEFAULT 'pending',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE rooms ADD COLUMN IF NOT EXISTS room_type VARCHAR(20) DEFAULT 'channel'`,
+		`ALTER TABLE rooms ADD COLUMN IF NOT EXISTS dm_key VARCHAR(255) UNIQUE`,
+		`ALTER TABLE room_members ADD COLUMN IF NOT EXISTS role VARCHAR(20) DEFAULT 'member'`,
+		`ALTER TABLE room_members ADD COLUMN IF NOT EXISTS last_read_message_id INTEGER`,
+		// Rooms created before roles existed get their creator as owner
+		`UPDATE room_members rm SET role = 'owner'
+		FROM rooms r
+		WHERE rm.room_id = r.id AND rm.user_id = r.created_by
+		  AND NOT EXISTS (SELECT 1 FROM room_members o WHERE o.room_id = r.id AND o.role = 'owner')`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_by INTEGER REFERENCES users(id) ON DELETE SET NULL`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES messages(id) ON DELETE CASCADE`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_count INTEGER DEFAULT 0`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS last_reply_at TIMESTAMP`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT`,
+		`ALTER TABLE rooms ADD COLUMN IF NOT EXISTS last_seq BIGINT DEFAULT 0`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_msg_id VARCHAR(64)`,
+		// Number messages written before sequences existed in their old order
+		`UPDATE messages SET seq = numbered.seq
+		FROM (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY room_id ORDER BY created_at, id) AS seq
+			FROM messages
+		) numbered
+		WHERE messages.id = numbered.id AND messages.seq IS NULL`,
+		`UPDATE rooms SET last_seq = COALESCE((SELECT MAX(seq) FROM messages WHERE room_id = rooms.id), 0)
+		WHERE last_seq < COALESCE((SELECT MAX(seq) FROM messages WHERE room_id = rooms.id), 0)`,
+		`CREATE TABLE IF NOT EXISTS message_edits (
+			id SERIAL PRIMARY KEY,
+			message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+			content TEXT NOT NULL,
+			edited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			edited_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS message_reactions (
+			id SERIAL PRIMARY KEY,
+			message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			emoji VARCHAR(32) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(message_id, user_id, emoji)
+		)`,
+		`CREATE TABLE IF NOT EXISTS message_mentions (
+			id SERIAL PRIMARY KEY,
+			message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			mention_type VARCHAR(10) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(message_id, user_id)
+		)`,
+		// Backplane events too large for a NOTIFY payload
+		`CREATE TABLE IF NOT EXISTS hub_events (
+			id BIGSERIAL PRIMARY KEY,
+			payload TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// Live WebSocket connections across every server instance
+		`CREATE TABLE IF NOT EXISTS user_connections (
+			id VARCHAR(32) PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			node_id VARCHAR(32) NOT NULL,
+			device VARCHAR(255) NOT NULL DEFAULT '',
+			connected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE user_connections ADD COLUMN IF NOT EXISTS idle BOOLEAN DEFAULT false`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS is_idle BOOLEAN DEFAULT false`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS presence_status VARCHAR(16) NOT NULL DEFAULT 'online'`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS status_text VARCHAR(100) NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS status_emoji VARCHAR(32) NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS status_expires_at TIMESTAMP`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_room_id ON messages(room_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_created_at ON messages(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_room_id_id ON messages(room_id, id)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_room_id_seq ON messages(room_id, seq)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_user_client_msg_id ON messages(user_id, client_msg_id) WHERE client_msg_id IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_family_id ON sessions(family_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_room_invitations_invitee_id ON room_invitations(invitee_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_message_edits_message_id ON message_edits(message_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_parent_id ON messages(parent_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_message_mentions_user_id ON message_mentions(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_connections_user_id ON user_connections(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_connections_node_id ON user_connections(node_id)`,
 	}
 
 	for _, query := range queries {
@@ -2,24 +2,33 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 	"real-time-chat/internal/auth"
 	"real-time-chat/internal/models"
 	"real-time-chat/internal/repository"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
-	userRepo  *repository.UserRepository
-	jwtSecret string
+	userRepo        *repository.UserRepository
+	sessionRepo     *repository.SessionRepository
+	jwtSecret       string
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
 }
 
-func NewAuthHandler(userRepo *repository.UserRepository, jwtSecret string) *AuthHandler {
+func NewAuthHandler(userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository,
+	jwtSecret string, accessTokenTTL, refreshTokenTTL time.Duration) *AuthHandler {
 	return &AuthHandler{
-		userRepo:  userRepo,
-		jwtSecret: jwtSecret,
+		userRepo:        userRepo,
+		sessionRepo:     sessionRepo,
+		jwtSecret:       jwtSecret,
+		accessTokenTTL:  accessTokenTTL,
+		refreshTokenTTL: refreshTokenTTL,
 	}
 }
 
@@ -60,16 +69,17 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := auth.GenerateToken(user.ID, user.Username, h.jwtSecret)
+	tokens, err := h.startSession(user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, models.AuthResponse{
-		Token: token,
-		User:  *user,
+		Token:        tokens.Token,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+		User:         *user,
 	})
 }
 
@@ -96,16 +106,17 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := auth.GenerateToken(user.ID, user.Username, h.jwtSecret)
+	tokens, err := h.startSession(user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, models.AuthResponse{
-		Token: token,
-		User:  *user,
+		Token:        tokens.Token,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+		User:         *user,
 	})
 }
 
@@ -124,3 +135,99 @@ func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, user)
 }
+
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req models.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	refreshToken, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to generate token"})
+		return
+	}
+
+	session, err := h.sessionRepo.Rotate(
+		auth.HashToken(req.RefreshToken),
+		auth.HashToken(refreshToken),
+		time.Now().Add(h.refreshTokenTTL),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrSessionNotFound),
+			errors.Is(err, repository.ErrSessionRevoked),
+			errors.Is(err, repository.ErrSessionExpired),
+			errors.Is(err, repository.ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Invalid or expired refresh token"})
+		default:
+			c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to refresh session"})
+		}
+		return
+	}
+
+	user, err := h.userRepo.GetByID(session.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "User not found"})
+		return
+	}
+
+	token, err := auth.GenerateToken(user.ID, user.Username, session.FamilyID, h.jwtSecret, h.accessTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.accessTokenTTL.Seconds()),
+	})
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	sessionID, _ := c.Get("sessionID")
+
+	if err := h.sessionRepo.RevokeFamily(sessionID.(string)); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to logout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// startSession opens a new session family for the user and issues the first
+// access/refresh token pair for it.
+func (h *AuthHandler) startSession(user *models.User) (*models.TokenResponse, error) {
+	familyID, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &models.Session{
+		FamilyID:  familyID,
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(h.refreshTokenTTL),
+	}
+	if err := h.sessionRepo.Create(session); err != nil {
+		return nil, err
+	}
+
+	token, err := auth.GenerateToken(user.ID, user.Username, familyID, h.jwtSecret, h.accessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.accessTokenTTL.Seconds()),
+	}, nil
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"real-time-chat/internal/models"
+	"real-time-chat/internal/repository"
+	"real-time-chat/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DMHandler struct {
+	roomRepo *repository.RoomRepository
+	userRepo *repository.UserRepository
+	hub      *websocket.Hub
+}
+
+func NewDMHandler(roomRepo *repository.RoomRepository, userRepo *repository.UserRepository,
+	hub *websocket.Hub) *DMHandler {
+	return &DMHandler{
+		roomRepo: roomRepo,
+		userRepo: userRepo,
+		hub:      hub,
+	}
+}
+
+// CreateDM finds or creates the conversation between the current user and
+// the requested users. Repeating the request returns the same room.
+func (h *DMHandler) CreateDM(c *gin.Context) {
+	var req models.CreateDMRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	participants := []int{userID.(int)}
+	seen := map[int]bool{userID.(int): true}
+	for _, id := range req.UserIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if _, err := h.userRepo.GetByID(id); err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "User not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Database error"})
+			return
+		}
+		participants = append(participants, id)
+	}
+
+	if len(participants) < 2 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "A DM needs at least one other user"})
+		return
+	}
+
+	room, created, err := h.roomRepo.FindOrCreateDM(userID.(int), participants)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to create DM"})
+		return
+	}
+
+	if !created {
+		c.JSON(http.StatusOK, room)
+		return
+	}
+
+	for _, id := range participants {
+		h.hub.SubscribeUser(room.ID, id)
+	}
+
+	c.JSON(http.StatusCreated, room)
+}
+
+func (h *DMHandler) GetDMs(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	dms, err := h.roomRepo.GetUserDMs(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to fetch DMs"})
+		return
+	}
+
+	if dms == nil {
+		dms = []*models.DirectMessage{}
+	}
+
+	c.JSON(http.StatusOK, dms)
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"real-time-chat/internal/auth"
+	"real-time-chat/internal/authz"
+	"real-time-chat/internal/models"
+	"real-time-chat/internal/repository"
+	"real-time-chat/internal/websocket"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InvitationHandler struct {
+	invitationRepo *repository.InvitationRepository
+	roomRepo       *repository.RoomRepository
+	userRepo       *repository.UserRepository
+	authorizer     *authz.Authorizer
+	hub            *websocket.Hub
+}
+
+func NewInvitationHandler(invitationRepo *repository.InvitationRepository, roomRepo *repository.RoomRepository,
+	userRepo *repository.UserRepository, authorizer *authz.Authorizer, hub *websocket.Hub) *InvitationHandler {
+	return &InvitationHandler{
+		invitationRepo: invitationRepo,
+		roomRepo:       roomRepo,
+		userRepo:       userRepo,
+		authorizer:     authorizer,
+		hub:            hub,
+	}
+}
+
+func (h *InvitationHandler) InviteUser(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid room ID"})
+		return
+	}
+
+	var req models.CreateInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	username, _ := c.Get("username")
+
+	room, err := h.authorizer.CanInvite(userID.(int), roomID)
+	if err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	if _, err := h.userRepo.GetByID(req.UserID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	isMember, err := h.roomRepo.IsMember(roomID, req.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Database error"})
+		return
+	}
+	if isMember {
+		c.JSON(http.StatusConflict, models.ErrorResponse{Error: "User is already a member of this room"})
+		return
+	}
+
+	invitation := &models.Invitation{
+		RoomID:      room.ID,
+		RoomName:    room.Name,
+		InviterID:   userID.(int),
+		InviterName: username.(string),
+		InviteeID:   &req.UserID,
+	}
+	if err := h.invitationRepo.Create(invitation); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to create invitation"})
+		return
+	}
+
+	h.hub.SendToUser(req.UserID, models.WSMessage{
+		Type:    "room_invite",
+		Payload: invitation,
+	})
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+func (h *InvitationHandler) CreateInviteLink(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid room ID"})
+		return
+	}
+
+	var req models.CreateInviteLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	username, _ := c.Get("username")
+
+	room, err := h.authorizer.CanInvite(userID.(int), roomID)
+	if err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	token, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to generate invite link"})
+		return
+	}
+
+	invitation := &models.Invitation{
+		RoomID:      room.ID,
+		RoomName:    room.Name,
+		InviterID:   userID.(int),
+		InviterName: username.(string),
+		Token:       token,
+		MaxUses:     req.MaxUses,
+	}
+	if req.ExpiresInHours > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		invitation.ExpiresAt = &expiresAt
+	}
+
+	if err := h.invitationRepo.Create(invitation); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to create invite link"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+func (h *InvitationHandler) GetRoomInvitations(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid room ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	if _, err := h.authorizer.CanInvite(userID.(int), roomID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	role, err := h.authorizer.Role(userID.(int), roomID)
+	if err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	invitations, err := h.invitationRepo.GetByRoomID(roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to fetch invitations"})
+		return
+	}
+
+	hideTokens(invitations, userID.(int), role)
+
+	if invitations == nil {
+		invitations = []*models.Invitation{}
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// hideTokens blanks the invite link tokens a member with role may not see.
+// Anyone holding a token can join, so only the link's creator and those
+// who manage invitations get to see it.
+func hideTokens(invitations []*models.Invitation, userID int, role string) {
+	if authz.HasPermission(role, authz.PermManageInvitations) {
+		return
+	}
+	for _, invitation := range invitations {
+		if invitation.InviterID != userID {
+			invitation.Token = ""
+		}
+	}
+}
+
+func (h *InvitationHandler) GetMyInvitations(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	invitations, err := h.invitationRepo.GetPendingForUser(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to fetch invitations"})
+		return
+	}
+
+	if invitations == nil {
+		invitations = []*models.Invitation{}
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+func (h *InvitationHandler) AcceptInvitation(c *gin.Context) {
+	invitationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid invitation ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	invitation, err := h.invitationRepo.Accept(invitationID, userID.(int))
+	if err != nil {
+		respondInvitationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invitation)
+}
+
+func (h *InvitationHandler) DeclineInvitation(c *gin.Context) {
+	invitationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid invitation ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	invitation, err := h.invitationRepo.GetByID(invitationID)
+	if err != nil {
+		respondInvitationError(c, err)
+		return
+	}
+
+	if invitation.InviteeID == nil || *invitation.InviteeID != userID.(int) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "Invitation not found"})
+		return
+	}
+
+	if err := h.invitationRepo.SetStatus(invitationID, models.InvitationDeclined); err != nil {
+		respondInvitationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation declined"})
+}
+
+func (h *InvitationHandler) RevokeInvitation(c *gin.Context) {
+	invitationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid invitation ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	invitation, err := h.invitationRepo.GetByID(invitationID)
+	if err != nil {
+		respondInvitationError(c, err)
+		return
+	}
+
+	// The person who sent the invitation may always revoke it; otherwise it
+	// takes a room moderator
+	if invitation.InviterID != userID.(int) {
+		if _, err := h.authorizer.Require(userID.(int), invitation.RoomID, authz.PermManageInvitations); err != nil {
+			respondAuthzError(c, err)
+			return
+		}
+	}
+
+	if err := h.invitationRepo.SetStatus(invitationID, models.InvitationRevoked); err != nil {
+		respondInvitationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+}
+
+func (h *InvitationHandler) RedeemInviteLink(c *gin.Context) {
+	token := c.Param("token")
+	userID, _ := c.Get("userID")
+
+	invitation, err := h.invitationRepo.RedeemLink(token, userID.(int))
+	if err != nil {
+		respondInvitationError(c, err)
+		return
+	}
+
+	// Never echo the link token back to someone who merely used it
+	invitation.Token = ""
+
+	c.JSON(http.StatusOK, invitation)
+}
+
+func respondInvitationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, repository.ErrInvitationNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "Invitation not found"})
+	case errors.Is(err, repository.ErrInvitationClosed),
+		errors.Is(err, repository.ErrInvitationExpired),
+		errors.Is(err, repository.ErrInvitationExhausted):
+		c.JSON(http.StatusGone, models.ErrorResponse{Error: err.Error()})
+	default:
+		log.Printf("Invitation error: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to process invitation"})
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"real-time-chat/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestHideTokens(t *testing.T) {
+	tests := []struct {
+		role string
+		want []string
+	}{
+		{models.RoleMember, []string{"mine", "", ""}},
+		{models.RoleModerator, []string{"mine", "theirs", ""}},
+		{models.RoleOwner, []string{"mine", "theirs", ""}},
+	}
+
+	for _, tt := range tests {
+		invitations := []*models.Invitation{
+			{InviterID: 1, Token: "mine"},
+			{InviterID: 2, Token: "theirs"},
+			{InviterID: 2},
+		}
+		hideTokens(invitations, 1, tt.role)
+
+		got := make([]string, len(invitations))
+		for i, invitation := range invitations {
+			got[i] = invitation.Token
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s sees tokens %q, want %q", tt.role, got, tt.want)
+		}
+	}
+}
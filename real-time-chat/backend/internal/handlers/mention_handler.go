@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"real-time-chat/internal/models"
+	"real-time-chat/internal/repository"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MentionHandler struct {
+	mentionRepo *repository.MentionRepository
+}
+
+func NewMentionHandler(mentionRepo *repository.MentionRepository) *MentionHandler {
+	return &MentionHandler{mentionRepo: mentionRepo}
+}
+
+func (h *MentionHandler) GetMentions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	limit := 50
+	offset := 0
+
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil {
+			limit = parsed
+		}
+	}
+
+	if o := c.Query("offset"); o != "" {
+		if parsed, err := strconv.Atoi(o); err == nil {
+			offset = parsed
+		}
+	}
+	if offset < 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Offset must not be negative"})
+		return
+	}
+
+	mentions, err := h.mentionRepo.GetForUser(userID.(int), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to fetch mentions"})
+		return
+	}
+
+	if mentions == nil {
+		mentions = []*models.Mention{}
+	}
+
+	c.JSON(http.StatusOK, mentions)
+}
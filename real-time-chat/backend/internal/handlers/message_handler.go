@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"real-time-chat/internal/authz"
+	"real-time-chat/internal/models"
+	"real-time-chat/internal/repository"
+	"real-time-chat/internal/websocket"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MessageHandler struct {
+	messageRepo *repository.MessageRepository
+	authorizer  *authz.Authorizer
+	hub         *websocket.Hub
+}
+
+func NewMessageHandler(messageRepo *repository.MessageRepository, authorizer *authz.Authorizer,
+	hub *websocket.Hub) *MessageHandler {
+	return &MessageHandler{
+		messageRepo: messageRepo,
+		authorizer:  authorizer,
+		hub:         hub,
+	}
+}
+
+func (h *MessageHandler) EditMessage(c *gin.Context) {
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid message ID"})
+		return
+	}
+
+	var req models.EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	message, err := h.messageRepo.GetByID(messageID)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	if err := h.authorizer.CanEditMessage(userID.(int), message); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	if err := h.messageRepo.Edit(message, req.Content, userID.(int)); err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	h.hub.BroadcastEvent(message.RoomID, models.WSMessage{
+		Type:    "message_updated",
+		Payload: message,
+	})
+
+	c.JSON(http.StatusOK, message)
+}
+
+func (h *MessageHandler) DeleteMessage(c *gin.Context) {
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid message ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	message, err := h.messageRepo.GetByID(messageID)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	if err := h.authorizer.CanDeleteMessage(userID.(int), message); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	parent, err := h.messageRepo.Delete(message, userID.(int))
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	h.hub.BroadcastEvent(message.RoomID, models.WSMessage{
+		Type: "message_deleted",
+		Payload: map[string]interface{}{
+			"message_id": message.ID,
+			"room_id":    message.RoomID,
+			"deleted_by": userID.(int),
+		},
+	})
+	if parent != nil {
+		h.hub.BroadcastThreadUpdated(parent)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully"})
+}
+
+func (h *MessageHandler) GetMessageHistory(c *gin.Context) {
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid message ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	message, err := h.messageRepo.GetByID(messageID)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	if _, err := h.authorizer.Require(userID.(int), message.RoomID, authz.PermViewEditHistory); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	edits, err := h.messageRepo.GetEditHistory(messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to fetch edit history"})
+		return
+	}
+
+	if edits == nil {
+		edits = []*models.MessageEdit{}
+	}
+
+	c.JSON(http.StatusOK, edits)
+}
+
+func (h *MessageHandler) GetThread(c *gin.Context) {
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid message ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	message, err := h.messageRepo.GetByID(messageID)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	if _, err := h.authorizer.CanView(userID.(int), message.RoomID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	thread, err := h.messageRepo.GetThread(messageID, userID.(int))
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, thread)
+}
+
+func respondMessageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, repository.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "Message not found"})
+		return
+	case errors.Is(err, repository.ErrMessageDeleted):
+		c.JSON(http.StatusGone, models.ErrorResponse{Error: "Message has been deleted"})
+		return
+	case errors.Is(err, repository.ErrInvalidParent):
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to process message"})
+}
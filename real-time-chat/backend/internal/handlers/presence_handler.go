@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"real-time-chat/internal/models"
+	"real-time-chat/internal/repository"
+	"real-time-chat/internal/websocket"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PresenceHandler struct {
+	userRepo       *repository.UserRepository
+	connectionRepo *repository.ConnectionRepository
+	hub            *websocket.Hub
+}
+
+func NewPresenceHandler(userRepo *repository.UserRepository, connectionRepo *repository.ConnectionRepository, hub *websocket.Hub) *PresenceHandler {
+	return &PresenceHandler{
+		userRepo:       userRepo,
+		connectionRepo: connectionRepo,
+		hub:            hub,
+	}
+}
+
+// GetDevices lists the current user's live connections across every server
+// instance.
+func (h *PresenceHandler) GetDevices(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	devices, err := h.connectionRepo.GetByUser(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to fetch devices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, devices)
+}
+
+// GetStatus returns the presence the current user chose, which unlike what
+// others see may be invisible.
+func (h *PresenceHandler) GetStatus(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	status, err := h.userRepo.GetStatus(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to fetch status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// SetStatus replaces the current user's presence and custom status and
+// announces the change.
+func (h *PresenceHandler) SetStatus(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var status models.UserStatus
+	if err := c.ShouldBindJSON(&status); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if status.ExpiresAt != nil && !status.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Expiry must be in the future"})
+		return
+	}
+	// Only a custom status expires
+	if status.Text == "" && status.Emoji == "" {
+		status.ExpiresAt = nil
+	}
+
+	if err := h.userRepo.SetStatus(userID.(int), &status); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to update status"})
+		return
+	}
+
+	h.hub.PresenceChanged(userID.(int))
+	c.JSON(http.StatusOK, status)
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
+	"log"
 	"net/http"
+	"real-time-chat/internal/authz"
 	"real-time-chat/internal/models"
 	"real-time-chat/internal/repository"
+	"real-time-chat/internal/websocket"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -12,12 +17,17 @@ import (
 type RoomHandler struct {
 	roomRepo    *repository.RoomRepository
 	messageRepo *repository.MessageRepository
+	authorizer  *authz.Authorizer
+	hub         *websocket.Hub
 }
 
-func NewRoomHandler(roomRepo *repository.RoomRepository, messageRepo *repository.MessageRepository) *RoomHandler {
+func NewRoomHandler(roomRepo *repository.RoomRepository, messageRepo *repository.MessageRepository,
+	authorizer *authz.Authorizer, hub *websocket.Hub) *RoomHandler {
 	return &RoomHandler{
 		roomRepo:    roomRepo,
 		messageRepo: messageRepo,
+		authorizer:  authorizer,
+		hub:         hub,
 	}
 }
 
@@ -42,8 +52,11 @@ func (h *RoomHandler) CreateRoom(c *gin.Context) {
 		return
 	}
 
-	// Add creator as member
-	h.roomRepo.AddMember(room.ID, userID.(int))
+	// Add creator as owner
+	if err := h.roomRepo.AddMemberWithRole(room.ID, userID.(int), models.RoleOwner); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to add room owner"})
+		return
+	}
 
 	c.JSON(http.StatusCreated, room)
 }
@@ -69,9 +82,11 @@ func (h *RoomHandler) GetRoom(c *gin.Context) {
 		return
 	}
 
-	room, err := h.roomRepo.GetByID(roomID)
+	userID, _ := c.Get("userID")
+
+	room, err := h.authorizer.CanView(userID.(int), roomID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "Room not found"})
+		respondAuthzError(c, err)
 		return
 	}
 
@@ -88,7 +103,7 @@ func (h *RoomHandler) GetUserRooms(c *gin.Context) {
 	}
 
 	if rooms == nil {
-		rooms = []*models.Room{}
+		rooms = []*models.UserRoom{}
 	}
 
 	c.JSON(http.StatusOK, rooms)
@@ -103,6 +118,11 @@ func (h *RoomHandler) JoinRoom(c *gin.Context) {
 
 	userID, _ := c.Get("userID")
 
+	if _, err := h.authorizer.CanJoin(userID.(int), roomID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
 	if err := h.roomRepo.AddMember(roomID, userID.(int)); err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to join room"})
 		return
@@ -120,6 +140,27 @@ func (h *RoomHandler) LeaveRoom(c *gin.Context) {
 
 	userID, _ := c.Get("userID")
 
+	role, err := h.authorizer.Role(userID.(int), roomID)
+	if err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+	if role == models.RoleOwner {
+		c.JSON(http.StatusConflict, models.ErrorResponse{Error: "Transfer ownership before leaving the room"})
+		return
+	}
+
+	room, err := h.roomRepo.GetByID(roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to leave room"})
+		return
+	}
+	// A DM is defined by its participants, so leaving one would strand it
+	if room.Type == models.RoomTypeDM {
+		c.JSON(http.StatusConflict, models.ErrorResponse{Error: "Direct messages cannot be left"})
+		return
+	}
+
 	if err := h.roomRepo.RemoveMember(roomID, userID.(int)); err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to leave room"})
 		return
@@ -135,6 +176,13 @@ func (h *RoomHandler) GetRoomMembers(c *gin.Context) {
 		return
 	}
 
+	userID, _ := c.Get("userID")
+
+	if _, err := h.authorizer.CanView(userID.(int), roomID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
 	members, err := h.roomRepo.GetMembers(roomID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to fetch members"})
@@ -142,7 +190,7 @@ func (h *RoomHandler) GetRoomMembers(c *gin.Context) {
 	}
 
 	if members == nil {
-		members = []*models.User{}
+		members = []*models.Member{}
 	}
 
 	c.JSON(http.StatusOK, members)
@@ -155,30 +203,277 @@ func (h *RoomHandler) GetRoomMessages(c *gin.Context) {
 		return
 	}
 
-	limit := 50
-	offset := 0
+	userID, _ := c.Get("userID")
 
-	if l := c.Query("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil {
-			limit = parsed
-		}
+	if _, err := h.authorizer.CanView(userID.(int), roomID); err != nil {
+		respondAuthzError(c, err)
+		return
 	}
 
-	if o := c.Query("offset"); o != "" {
-		if parsed, err := strconv.Atoi(o); err == nil {
-			offset = parsed
+	query := models.MessageQuery{IncludeReplies: c.Query("include_replies") == "true"}
+	params := []struct {
+		key  string
+		dest *int
+	}{
+		{"before_id", &query.BeforeID},
+		{"after_id", &query.AfterID},
+		{"around_id", &query.AroundID},
+		{"limit", &query.Limit},
+	}
+	for _, param := range params {
+		value, err := queryInt(c, param.key)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid " + param.key})
+			return
 		}
+		*param.dest = value
 	}
 
-	messages, err := h.messageRepo.GetByRoomID(roomID, limit, offset)
+	page, err := h.messageRepo.GetByRoomID(roomID, userID.(int), query)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to fetch messages"})
 		return
 	}
 
-	if messages == nil {
-		messages = []*models.Message{}
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *RoomHandler) UpdateRoom(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid room ID"})
+		return
+	}
+
+	var req models.UpdateRoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	if _, err := h.authorizer.Require(userID.(int), roomID, authz.PermEditRoom); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	room, err := h.roomRepo.GetByID(roomID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "Room not found"})
+		return
+	}
+
+	if req.Name != nil {
+		room.Name = *req.Name
+	}
+	if req.Description != nil {
+		room.Description = *req.Description
+	}
+	if req.IsPrivate != nil {
+		room.IsPrivate = *req.IsPrivate
+	}
+
+	if err := h.roomRepo.Update(room); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to update room"})
+		return
+	}
+
+	h.hub.BroadcastEvent(roomID, models.WSMessage{
+		Type:    "room_updated",
+		Payload: room,
+	})
+
+	c.JSON(http.StatusOK, room)
+}
+
+func (h *RoomHandler) DeleteRoom(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid room ID"})
+		return
 	}
 
-	c.JSON(http.StatusOK, messages)
+	userID, _ := c.Get("userID")
+
+	if _, err := h.authorizer.Require(userID.(int), roomID, authz.PermDeleteRoom); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	if err := h.roomRepo.Delete(roomID); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to delete room"})
+		return
+	}
+
+	h.hub.CloseRoom(roomID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Room deleted successfully"})
+}
+
+func (h *RoomHandler) UpdateMemberRole(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid room ID"})
+		return
+	}
+
+	targetID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	var req models.UpdateMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if !authz.IsValidRole(req.Role) || req.Role == models.RoleOwner {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Role must be admin, moderator or member"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	actorRole, _, err := h.authorizer.CanActOn(userID.(int), targetID, roomID, authz.PermManageRoles)
+	if err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	// Nobody can hand out a role at or above their own
+	if !authz.Outranks(actorRole, req.Role) {
+		respondAuthzError(c, authz.ErrForbidden)
+		return
+	}
+
+	if err := h.roomRepo.SetMemberRole(roomID, targetID, req.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to update role"})
+		return
+	}
+
+	h.hub.BroadcastEvent(roomID, models.WSMessage{
+		Type: "member_role_updated",
+		Payload: map[string]interface{}{
+			"room_id": roomID,
+			"user_id": targetID,
+			"role":    req.Role,
+		},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated successfully"})
+}
+
+func (h *RoomHandler) TransferOwnership(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid room ID"})
+		return
+	}
+
+	var req models.TransferOwnershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	if req.UserID == userID.(int) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "You already own this room"})
+		return
+	}
+
+	if _, _, err := h.authorizer.CanActOn(userID.(int), req.UserID, roomID, authz.PermTransferOwnership); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	if err := h.roomRepo.TransferOwnership(roomID, userID.(int), req.UserID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondAuthzError(c, authz.ErrNotMember)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to transfer ownership"})
+		return
+	}
+
+	h.hub.BroadcastEvent(roomID, models.WSMessage{
+		Type: "member_role_updated",
+		Payload: map[string]interface{}{
+			"room_id": roomID,
+			"user_id": req.UserID,
+			"role":    models.RoleOwner,
+		},
+	})
+	h.hub.BroadcastEvent(roomID, models.WSMessage{
+		Type: "member_role_updated",
+		Payload: map[string]interface{}{
+			"room_id": roomID,
+			"user_id": userID.(int),
+			"role":    models.RoleAdmin,
+		},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ownership transferred successfully"})
+}
+
+func (h *RoomHandler) KickMember(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid room ID"})
+		return
+	}
+
+	targetID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	if _, _, err := h.authorizer.CanActOn(userID.(int), targetID, roomID, authz.PermKickMember); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	if err := h.roomRepo.RemoveMember(roomID, targetID); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to remove member"})
+		return
+	}
+
+	h.hub.KickFromRoom(roomID, targetID, userID.(int))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// queryInt reads an optional integer query parameter, treating a missing
+// one as zero.
+func queryInt(c *gin.Context, key string) (int, error) {
+	value := c.Query(key)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// respondAuthzError translates an authorization failure into the matching
+// HTTP status so callers never leak data on a denied request.
+func respondAuthzError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, authz.ErrRoomNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "Room not found"})
+	case errors.Is(err, authz.ErrNotMember):
+		c.JSON(http.StatusForbidden, models.ErrorResponse{Error: "You are not a member of this room"})
+	case errors.Is(err, authz.ErrInviteOnly):
+		c.JSON(http.StatusForbidden, models.ErrorResponse{Error: "This room is private and requires an invitation"})
+	case errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, models.ErrorResponse{Error: "You do not have permission to do that"})
+	default:
+		log.Printf("Authorization check failed: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to check permissions"})
+	}
 }
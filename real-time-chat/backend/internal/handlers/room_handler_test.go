@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestQueryInt(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"before_id=", 0, false},
+		{"before_id=42", 42, false},
+		{"before_id=-3", -3, false},
+		{"before_id=abc", 0, true},
+		{"before_id=4.5", 0, true},
+		{"before_id=12abc", 0, true},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/api/rooms/1/messages?"+tt.query, nil)
+
+		got, err := queryInt(c, "before_id")
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("queryInt(%q) = %d, %v; want %d, error %v", tt.query, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
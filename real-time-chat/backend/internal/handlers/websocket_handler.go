@@ -3,6 +3,7 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"real-time-chat/internal/authz"
 	"real-time-chat/internal/repository"
 	"real-time-chat/internal/websocket"
 
@@ -10,9 +11,13 @@ import (
 	ws "github.com/gorilla/websocket"
 )
 
+// maxDeviceNameSize matches the user_connections.device column width
+const maxDeviceNameSize = 255
+
 var upgrader = ws.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    websocket.Subprotocols(),
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins in development
 	},
@@ -22,13 +27,18 @@ type WebSocketHandler struct {
 	hub         *websocket.Hub
 	messageRepo *repository.MessageRepository
 	roomRepo    *repository.RoomRepository
+	mentionRepo *repository.MentionRepository
+	authorizer  *authz.Authorizer
 }
 
-func NewWebSocketHandler(hub *websocket.Hub, messageRepo *repository.MessageRepository, roomRepo *repository.RoomRepository) *WebSocketHandler {
+func NewWebSocketHandler(hub *websocket.Hub, messageRepo *repository.MessageRepository, roomRepo *repository.RoomRepository,
+	mentionRepo *repository.MentionRepository, authorizer *authz.Authorizer) *WebSocketHandler {
 	return &WebSocketHandler{
 		hub:         hub,
 		messageRepo: messageRepo,
 		roomRepo:    roomRepo,
+		mentionRepo: mentionRepo,
+		authorizer:  authorizer,
 	}
 }
 
@@ -52,16 +62,42 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 		conn,
 		userID.(int),
 		username.(string),
+		deviceName(c),
 		h.messageRepo,
 		h.roomRepo,
+		h.mentionRepo,
+		h.authorizer,
 	)
 
+	client.Welcome()
 	h.hub.Register(client)
 
+	// DMs are delivered without the client having to join them first
+	dmRoomIDs, err := h.roomRepo.GetUserDMRoomIDs(userID.(int))
+	if err != nil {
+		log.Printf("Error loading DM rooms: %v", err)
+	}
+	for _, roomID := range dmRoomIDs {
+		h.hub.Subscribe(client, roomID)
+	}
+
 	go client.WritePump()
 	go client.ReadPump()
 }
 
+// deviceName labels a connection in the user's device list, preferring a
+// name the client chose over its User-Agent.
+func deviceName(c *gin.Context) string {
+	device := c.Query("device")
+	if device == "" {
+		device = c.Request.UserAgent()
+	}
+	if len(device) > maxDeviceNameSize {
+		device = device[:maxDeviceNameSize]
+	}
+	return device
+}
+
 func (h *WebSocketHandler) GetHub() *websocket.Hub {
 	return h.hub
 }
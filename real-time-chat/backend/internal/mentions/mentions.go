@@ -0,0 +1,54 @@
+package mentions
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	TypeUser = "user"
+	TypeHere = "here"
+	TypeRoom = "room"
+)
+
+// mentionPattern matches @name when it starts the text or follows a
+// character that cannot be part of a word, so e-mail addresses are ignored.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_]{1,50})`)
+
+type Parsed struct {
+	Usernames []string
+	Here      bool
+	Room      bool
+}
+
+// Parse extracts the distinct mentions from a message body. @here and @room
+// are reported as flags rather than usernames.
+func Parse(content string) Parsed {
+	var parsed Parsed
+	seen := make(map[string]bool)
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := match[1]
+		switch strings.ToLower(name) {
+		case TypeHere:
+			parsed.Here = true
+			continue
+		case TypeRoom:
+			parsed.Room = true
+			continue
+		}
+
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		parsed.Usernames = append(parsed.Usernames, name)
+	}
+
+	return parsed
+}
+
+func (p Parsed) Empty() bool {
+	return len(p.Usernames) == 0 && !p.Here && !p.Room
+}
@@ -0,0 +1,53 @@
+package mentions
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Parsed
+	}{
+		{"none", "hello there", Parsed{}},
+		{"single", "hi @alice", Parsed{Usernames: []string{"alice"}}},
+		{"start of text", "@bob look", Parsed{Usernames: []string{"bob"}}},
+		{"several in order", "@carol and @dave", Parsed{Usernames: []string{"carol", "dave"}}},
+		{"duplicates ignore case", "@Erin @erin @ERIN", Parsed{Usernames: []string{"Erin"}}},
+		{"punctuation around", "(@frank), @grace!", Parsed{Usernames: []string{"frank", "grace"}}},
+		{"email is not a mention", "mail me at heidi@example.com", Parsed{}},
+		{"double at", "@@ivan", Parsed{}},
+		{"here", "@here standup", Parsed{Here: true}},
+		{"room any case", "@Room heads up", Parsed{Room: true}},
+		{"flags and users", "@here @room @judy", Parsed{Usernames: []string{"judy"}, Here: true, Room: true}},
+		{"underscores and digits", "@user_42", Parsed{Usernames: []string{"user_42"}}},
+		{"bare at", "@ alone", Parsed{}},
+	}
+
+	for _, tt := range tests {
+		got := Parse(tt.content)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Parse(%q) = %+v, want %+v", tt.name, tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestParsedEmpty(t *testing.T) {
+	tests := []struct {
+		parsed Parsed
+		want   bool
+	}{
+		{Parsed{}, true},
+		{Parsed{Usernames: []string{"a"}}, false},
+		{Parsed{Here: true}, false},
+		{Parsed{Room: true}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.parsed.Empty(); got != tt.want {
+			t.Errorf("%+v.Empty() = %v, want %v", tt.parsed, got, tt.want)
+		}
+	}
+}
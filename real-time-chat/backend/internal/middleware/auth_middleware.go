@@ -3,12 +3,13 @@ package middleware
 import (
 	"net/http"
 	"real-time-chat/internal/auth"
+	"real-time-chat/internal/repository"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+func AuthMiddleware(jwtSecret string, sessionRepo *repository.SessionRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		
@@ -32,8 +33,22 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
+		// Tokens outlive logout unless we check the session they belong to
+		active, err := sessionRepo.IsActive(claims.SessionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify session"})
+			c.Abort()
+			return
+		}
+		if !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			c.Abort()
+			return
+		}
+
 		c.Set("userID", claims.UserID)
 		c.Set("username", claims.Username)
+		c.Set("sessionID", claims.SessionID)
 		c.Next()
 	}
 }
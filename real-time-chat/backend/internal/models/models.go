@@ -3,14 +3,52 @@ package models
 import "time"
 
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	AvatarURL    string    `json:"avatar_url"`
-	IsOnline     bool      `json:"is_online"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"-"`
+	AvatarURL    string `json:"avatar_url"`
+	IsOnline     bool   `json:"is_online"`
+
+	// Presence as other users see it: Status is one of the Presence*
+	// constants, with invisible users shown offline
+	Status          string     `json:"status"`
+	StatusText      string     `json:"status_text"`
+	StatusEmoji     string     `json:"status_emoji"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+	LastSeenAt      *time.Time `json:"last_seen_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const (
+	PresenceOnline    = "online"
+	PresenceAway      = "away"
+	PresenceDND       = "dnd"
+	PresenceInvisible = "invisible"
+	PresenceOffline   = "offline"
+)
+
+// Presence is the presence_snapshot and presence frame entry for one user.
+type Presence struct {
+	UserID          int        `json:"user_id"`
+	Username        string     `json:"username"`
+	Status          string     `json:"status"`
+	StatusText      string     `json:"status_text"`
+	StatusEmoji     string     `json:"status_emoji"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+	LastSeenAt      *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// UserStatus is the presence a user chose for themselves. Presence is
+// online, away, dnd or invisible; away is also set automatically while
+// every connection is idle.
+type UserStatus struct {
+	Presence  string     `json:"presence" binding:"required,oneof=online away dnd invisible"`
+	Text      string     `json:"text" binding:"max=100"`
+	Emoji     string     `json:"emoji" binding:"max=32"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 type Room struct {
@@ -19,51 +57,274 @@ type Room struct {
 	Description string    `json:"description"`
 	CreatedBy   int       `json:"created_by"`
 	IsPrivate   bool      `json:"is_private"`
+	Type        string    `json:"type"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+const (
+	RoomTypeChannel = "channel"
+	RoomTypeDM      = "dm"
+)
+
+// DirectMessage is a DM conversation as listed for one of its participants.
+type DirectMessage struct {
+	Room         *Room    `json:"room"`
+	Participants []*User  `json:"participants"`
+	LastMessage  *Message `json:"last_message,omitempty"`
+}
+
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
 type RoomMember struct {
 	ID       int       `json:"id"`
 	RoomID   int       `json:"room_id"`
 	UserID   int       `json:"user_id"`
+	Role     string    `json:"role"`
 	JoinedAt time.Time `json:"joined_at"`
 }
 
+// Member is a user as seen from inside a room.
+type Member struct {
+	User
+	Role              string    `json:"role"`
+	JoinedAt          time.Time `json:"joined_at"`
+	LastReadMessageID *int      `json:"last_read_message_id,omitempty"`
+}
+
+// UserRoom is a room as seen by one of its members.
+type UserRoom struct {
+	Room
+	UnreadCount       int  `json:"unread_count"`
+	LastReadMessageID *int `json:"last_read_message_id,omitempty"`
+}
+
 type Message struct {
+	ID          int        `json:"id"`
+	RoomID      int        `json:"room_id"`
+	Seq         int64      `json:"seq"`
+	UserID      int        `json:"user_id"`
+	Username    string     `json:"username"`
+	Content     string     `json:"content"`
+	MessageType string     `json:"message_type"`
+	CreatedAt   time.Time  `json:"created_at"`
+	EditedAt    *time.Time `json:"edited_at,omitempty"`
+	IsDeleted   bool       `json:"is_deleted"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy   int        `json:"deleted_by,omitempty"`
+	Reactions   []Reaction `json:"reactions,omitempty"`
+	ParentID    *int       `json:"parent_id,omitempty"`
+	ReplyCount  int        `json:"reply_count"`
+	LastReplyAt *time.Time `json:"last_reply_at,omitempty"`
+	ClientMsgID *string    `json:"client_msg_id,omitempty"`
+}
+
+// Mention is an entry in a user's mentions inbox.
+type Mention struct {
 	ID          int       `json:"id"`
+	MessageID   int       `json:"message_id"`
 	RoomID      int       `json:"room_id"`
-	UserID      int       `json:"user_id"`
-	Username    string    `json:"username"`
+	RoomName    string    `json:"room_name"`
+	MentionType string    `json:"mention_type"`
+	AuthorID    int       `json:"author_id"`
+	AuthorName  string    `json:"author_name"`
 	Content     string    `json:"content"`
-	MessageType string    `json:"message_type"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// MessageQuery selects a page of room history. At most one of the anchors
+// is used, in the order AroundID, BeforeID, AfterID; with none set the
+// latest messages are returned. IncludeReplies interleaves thread replies
+// in sequence order, which is how a client fills a gap in seq.
+type MessageQuery struct {
+	BeforeID       int
+	AfterID        int
+	AroundID       int
+	Limit          int
+	IncludeReplies bool
+}
+
+// MessageCursor holds the query parameters that fetch an adjacent page.
+type MessageCursor struct {
+	BeforeID       int  `json:"before_id,omitempty"`
+	AfterID        int  `json:"after_id,omitempty"`
+	IncludeReplies bool `json:"include_replies,omitempty"`
+}
+
+// MessagePage is a slice of room history in chronological order. Prev is
+// set when older messages exist and Next when newer ones do.
+type MessagePage struct {
+	Messages []*Message     `json:"messages"`
+	Prev     *MessageCursor `json:"prev"`
+	Next     *MessageCursor `json:"next"`
+}
+
+type Thread struct {
+	Parent  *Message   `json:"parent"`
+	Replies []*Message `json:"replies"`
+}
+
+// Reaction aggregates every use of one emoji on a message.
+type Reaction struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+// MessageEdit is a previous revision of a message, stored each time its
+// author edits it.
+type MessageEdit struct {
+	ID        int       `json:"id"`
+	MessageID int       `json:"message_id"`
+	Content   string    `json:"content"`
+	EditedBy  int       `json:"edited_by"`
+	EditedAt  time.Time `json:"edited_at"`
+}
+
+type Session struct {
+	ID        int        `json:"id"`
+	FamilyID  string     `json:"family_id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Connection is one live WebSocket connection of a user, such as a browser
+// tab or a phone.
+type Connection struct {
+	ID          string    `json:"id"`
+	UserID      int       `json:"user_id"`
+	Device      string    `json:"device"`
+	Idle        bool      `json:"idle"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationRevoked  = "revoked"
+)
+
+// Invitation is either addressed to a single user (InviteeID set) or is a
+// shareable link (Token set) that can be redeemed up to MaxUses times.
+type Invitation struct {
+	ID          int        `json:"id"`
+	RoomID      int        `json:"room_id"`
+	RoomName    string     `json:"room_name"`
+	InviterID   int        `json:"inviter_id"`
+	InviterName string     `json:"inviter_name"`
+	InviteeID   *int       `json:"invitee_id,omitempty"`
+	Token       string     `json:"token,omitempty"`
+	MaxUses     *int       `json:"max_uses,omitempty"`
+	UseCount    int        `json:"use_count"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
 // WebSocket message types
 type WSMessage struct {
+	ID      string      `json:"id,omitempty"`
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
 }
 
+// WSError is the payload of an error frame. Code is stable and meant for
+// programs; Message is for people.
+type WSError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	RoomID  int    `json:"room_id,omitempty"`
+}
+
 type ChatMessage struct {
-	RoomID  int    `json:"room_id"`
-	Content string `json:"content"`
+	RoomID      int    `json:"room_id"`
+	Content     string `json:"content"`
+	ParentID    *int   `json:"parent_id,omitempty"`
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+}
+
+// MessageAck confirms a send_message frame was stored.
+type MessageAck struct {
+	ClientMsgID string    `json:"client_msg_id,omitempty"`
+	MessageID   int       `json:"message_id"`
+	RoomID      int       `json:"room_id"`
+	Seq         int64     `json:"seq"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// MessageError reports that a send_message frame was not stored.
+type MessageError struct {
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+	RoomID      int    `json:"room_id"`
+	Code        string `json:"code"`
+	Error       string `json:"error"`
 }
 
 type JoinRoom struct {
-	RoomID int `json:"room_id"`
+	RoomID        int `json:"room_id"`
+	LastMessageID int `json:"last_message_id,omitempty"`
+}
+
+// ResumeRoom names the last message a client saw in a room before its
+// connection dropped.
+type ResumeRoom struct {
+	RoomID        int `json:"room_id"`
+	LastMessageID int `json:"last_message_id"`
+}
+
+type Resume struct {
+	Rooms []ResumeRoom `json:"rooms"`
 }
 
 type LeaveRoom struct {
 	RoomID int `json:"room_id"`
 }
 
+type EditMessage struct {
+	MessageID int    `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+type DeleteMessage struct {
+	MessageID int `json:"message_id"`
+}
+
+type MessageReaction struct {
+	MessageID int    `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+type MarkRead struct {
+	RoomID    int `json:"room_id"`
+	MessageID int `json:"message_id"`
+}
+
+type KickMember struct {
+	RoomID int `json:"room_id"`
+	UserID int `json:"user_id"`
+}
+
 type TypingIndicator struct {
 	RoomID   int    `json:"room_id"`
 	Username string `json:"username"`
 	IsTyping bool   `json:"is_typing"`
 }
 
+// Activity is sent by clients as the user interacts with them, or with Idle
+// set when the user steps away.
+type Activity struct {
+	Idle bool `json:"idle"`
+}
+
 // API Request/Response types
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=50"`
@@ -77,8 +338,20 @@ type LoginRequest struct {
 }
 
 type AuthResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	User         User   `json:"user"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
 }
 
 type CreateRoomRequest struct {
@@ -87,6 +360,40 @@ type CreateRoomRequest struct {
 	IsPrivate   bool   `json:"is_private"`
 }
 
+type CreateInvitationRequest struct {
+	UserID int `json:"user_id" binding:"required"`
+}
+
+type CreateInviteLinkRequest struct {
+	ExpiresInHours int  `json:"expires_in_hours" binding:"min=0"`
+	MaxUses        *int `json:"max_uses" binding:"omitempty,min=1"`
+}
+
+// CreateDMRequest names the other participants; the caller is added
+// implicitly.
+type CreateDMRequest struct {
+	UserIDs []int `json:"user_ids" binding:"required,min=1,max=8"`
+}
+
+// UpdateRoomRequest only touches the fields that are present in the body.
+type UpdateRoomRequest struct {
+	Name        *string `json:"name" binding:"omitempty,min=1,max=100"`
+	Description *string `json:"description"`
+	IsPrivate   *bool   `json:"is_private"`
+}
+
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"required,min=1"`
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type TransferOwnershipRequest struct {
+	UserID int `json:"user_id" binding:"required"`
+}
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
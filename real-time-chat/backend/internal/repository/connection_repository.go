@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"database/sql"
+	"real-time-chat/internal/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ConnectionRepository tracks live WebSocket connections so a user counts
+// as online while any of their devices is connected to any instance, and
+// as idle only once all of them are.
+type ConnectionRepository struct {
+	db *sql.DB
+}
+
+func NewConnectionRepository(db *sql.DB) *ConnectionRepository {
+	return &ConnectionRepository{db: db}
+}
+
+// ConnectionChanges is a batch of connection changes made on one instance.
+type ConnectionChanges struct {
+	Added   []*models.Connection
+	Removed []*models.Connection
+	// Idle holds connections whose idle flag changed
+	Idle []*models.Connection
+}
+
+// Apply records a batch of connection changes held by nodeID and updates
+// the presence of their users in one transaction, with one statement per
+// kind of change however many connections it covers.
+func (r *ConnectionRepository) Apply(changes ConnectionChanges, nodeID string) error {
+	if len(changes.Added)+len(changes.Removed)+len(changes.Idle) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userIDs := make(map[int]bool)
+
+	if len(changes.Added) > 0 {
+		ids := make([]string, len(changes.Added))
+		users := make([]int, len(changes.Added))
+		devices := make([]string, len(changes.Added))
+		idle := make([]bool, len(changes.Added))
+		for i, conn := range changes.Added {
+			ids[i], users[i], devices[i], idle[i] = conn.ID, conn.UserID, conn.Device, conn.Idle
+			userIDs[conn.UserID] = true
+		}
+		query := `
+			INSERT INTO user_connections (id, user_id, node_id, device, idle)
+			SELECT c.id, c.user_id, $5, c.device, c.idle
+			FROM unnest($1::varchar[], $2::int[], $3::varchar[], $4::boolean[]) AS c(id, user_id, device, idle)
+			ON CONFLICT (id) DO NOTHING
+		`
+		if _, err := tx.Exec(query, pq.Array(ids), pq.Array(users), pq.Array(devices), pq.Array(idle), nodeID); err != nil {
+			return err
+		}
+	}
+
+	if len(changes.Idle) > 0 {
+		ids := make([]string, len(changes.Idle))
+		idle := make([]bool, len(changes.Idle))
+		for i, conn := range changes.Idle {
+			ids[i], idle[i] = conn.ID, conn.Idle
+			userIDs[conn.UserID] = true
+		}
+		query := `
+			UPDATE user_connections uc SET idle = c.idle
+			FROM unnest($1::varchar[], $2::boolean[]) AS c(id, idle)
+			WHERE uc.id = c.id
+		`
+		if _, err := tx.Exec(query, pq.Array(ids), pq.Array(idle)); err != nil {
+			return err
+		}
+	}
+
+	if len(changes.Removed) > 0 {
+		ids := make([]string, len(changes.Removed))
+		for i, conn := range changes.Removed {
+			ids[i] = conn.ID
+			userIDs[conn.UserID] = true
+		}
+		if _, err := tx.Exec(`DELETE FROM user_connections WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+			return err
+		}
+	}
+
+	ids := make([]int, 0, len(userIDs))
+	for id := range userIDs {
+		ids = append(ids, id)
+	}
+	if err := syncPresence(tx, ids); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Touch marks every connection held by nodeID as still alive.
+func (r *ConnectionRepository) Touch(nodeID string) error {
+	query := `UPDATE user_connections SET seen_at = CURRENT_TIMESTAMP WHERE node_id = $1`
+	_, err := r.db.Exec(query, nodeID)
+	return err
+}
+
+// PruneStale removes connections whose instance stopped touching them,
+// typically because it crashed, and returns the users they belonged to.
+func (r *ConnectionRepository) PruneStale(maxAge time.Duration) ([]int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM user_connections
+		WHERE seen_at < NOW() - $1 * INTERVAL '1 second'
+		RETURNING user_id
+	`
+	rows, err := tx.Query(query, maxAge.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int]bool)
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := syncPresence(tx, userIDs); err != nil {
+		return nil, err
+	}
+	return userIDs, tx.Commit()
+}
+
+// Reset forgets every connection and marks everyone offline. It is only
+// safe when a single instance serves all clients.
+func (r *ConnectionRepository) Reset() error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_connections`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE users SET is_online = false, is_idle = false WHERE is_online`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Connected filters userIDs down to those with a live connection anywhere.
+// Unlike the online status shown to others, it includes invisible users.
+func (r *ConnectionRepository) Connected(userIDs []int) ([]int, error) {
+	query := `SELECT DISTINCT user_id FROM user_connections WHERE user_id = ANY($1)`
+	rows, err := r.db.Query(query, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var connected []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		connected = append(connected, id)
+	}
+	return connected, rows.Err()
+}
+
+// GetByUser lists a user's live connections, oldest first.
+func (r *ConnectionRepository) GetByUser(userID int) ([]*models.Connection, error) {
+	query := `
+		SELECT id, user_id, device, idle, connected_at
+		FROM user_connections WHERE user_id = $1
+		ORDER BY connected_at, id
+	`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	connections := []*models.Connection{}
+	for rows.Next() {
+		conn := &models.Connection{}
+		if err := rows.Scan(&conn.ID, &conn.UserID, &conn.Device, &conn.Idle, &conn.ConnectedAt); err != nil {
+			return nil, err
+		}
+		connections = append(connections, conn)
+	}
+	return connections, rows.Err()
+}
+
+// syncPresence derives users' online and idle flags from their connections
+// and records when they were last seen, unless invisible.
+func syncPresence(tx *sql.Tx, userIDs []int) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	query := `
+		UPDATE users u SET
+			is_online = EXISTS (SELECT 1 FROM user_connections c WHERE c.user_id = u.id),
+			is_idle = NOT EXISTS (SELECT 1 FROM user_connections c WHERE c.user_id = u.id AND NOT c.idle),
+			last_seen_at = CASE WHEN u.presence_status = 'invisible' THEN u.last_seen_at ELSE CURRENT_TIMESTAMP END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE u.id = ANY($1)
+	`
+	_, err := tx.Exec(query, pq.Array(userIDs))
+	return err
+}
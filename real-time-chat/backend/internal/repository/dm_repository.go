@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"database/sql"
+	"real-time-chat/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// dmKey identifies a DM by its exact set of participants, which is what
+// makes FindOrCreateDM idempotent.
+func dmKey(userIDs []int) string {
+	sorted := append([]int(nil), userIDs...)
+	sort.Ints(sorted)
+
+	parts := make([]string, len(sorted))
+	for i, id := range sorted {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+// FindOrCreateDM returns the DM room for exactly these participants,
+// creating it if needed. The boolean reports whether a new room was made.
+func (r *RoomRepository) FindOrCreateDM(creatorID int, userIDs []int) (*models.Room, bool, error) {
+	key := dmKey(userIDs)
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	room := &models.Room{
+		CreatedBy: creatorID,
+		IsPrivate: true,
+		Type:      models.RoomTypeDM,
+	}
+
+	// ON CONFLICT keeps concurrent requests for the same pair from racing
+	// each other into two rooms
+	err = tx.QueryRow(`
+		INSERT INTO rooms (name, description, created_by, is_private, room_type, dm_key)
+		VALUES ('', '', $1, true, $2, $3)
+		ON CONFLICT (dm_key) DO NOTHING
+		RETURNING id, created_at
+	`, creatorID, models.RoomTypeDM, key).Scan(&room.ID, &room.CreatedAt)
+	created := true
+	if err == sql.ErrNoRows {
+		room, err = scanRoom(tx.QueryRow(`SELECT `+roomColumns+` FROM rooms r WHERE r.dm_key = $1`, key))
+		created = false
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// An existing DM gets back any participant who is no longer a member,
+	// so the room returned is always one they can use
+	for _, userID := range userIDs {
+		if _, err := tx.Exec(`
+			INSERT INTO room_members (room_id, user_id, role) VALUES ($1, $2, $3)
+			ON CONFLICT (room_id, user_id) DO NOTHING
+		`, room.ID, userID, models.RoleMember); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return room, created, nil
+}
+
+// GetUserDMs lists the user's DM conversations, most recently active first,
+// with the other participants and a preview of the last message.
+func (r *RoomRepository) GetUserDMs(userID int) ([]*models.DirectMessage, error) {
+	query := `
+		SELECT ` + roomColumns + `,
+		       lm.id, COALESCE(lm.user_id, 0), COALESCE(lu.username, 'Deleted User'),
+		       CASE WHEN lm.deleted_at IS NULL THEN lm.content ELSE '' END,
+		       lm.message_type, lm.created_at, lm.deleted_at IS NOT NULL
+		FROM rooms r
+		INNER JOIN room_members rm ON r.id = rm.room_id
+		LEFT JOIN LATERAL (
+			SELECT * FROM messages m
+			WHERE m.room_id = r.id AND m.parent_id IS NULL
+			ORDER BY m.seq DESC
+			LIMIT 1
+		) lm ON true
+		LEFT JOIN users lu ON lm.user_id = lu.id
+		WHERE rm.user_id = $1 AND r.room_type = 'dm'
+		ORDER BY COALESCE(lm.created_at, r.created_at) DESC
+	`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dms []*models.DirectMessage
+	byRoom := make(map[int]*models.DirectMessage)
+	var roomIDs []int64
+	for rows.Next() {
+		room := &models.Room{}
+		var (
+			lastID        sql.NullInt64
+			lastUserID    sql.NullInt64
+			lastUsername  sql.NullString
+			lastContent   sql.NullString
+			lastType      sql.NullString
+			lastCreatedAt sql.NullTime
+			lastDeleted   sql.NullBool
+		)
+		err := rows.Scan(
+			&room.ID, &room.Name, &room.Description, &room.CreatedBy, &room.IsPrivate, &room.Type, &room.CreatedAt,
+			&lastID, &lastUserID, &lastUsername, &lastContent, &lastType, &lastCreatedAt, &lastDeleted,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		dm := &models.DirectMessage{Room: room, Participants: []*models.User{}}
+		if lastID.Valid {
+			dm.LastMessage = &models.Message{
+				ID:          int(lastID.Int64),
+				RoomID:      room.ID,
+				UserID:      int(lastUserID.Int64),
+				Username:    lastUsername.String,
+				Content:     lastContent.String,
+				MessageType: lastType.String,
+				CreatedAt:   lastCreatedAt.Time,
+				IsDeleted:   lastDeleted.Bool,
+			}
+		}
+
+		dms = append(dms, dm)
+		byRoom[room.ID] = dm
+		roomIDs = append(roomIDs, int64(room.ID))
+	}
+
+	if len(dms) == 0 {
+		return dms, nil
+	}
+
+	memberRows, err := r.db.Query(`
+		SELECT rm.room_id, u.id, u.username, u.email, u.avatar_url, u.created_at, u.updated_at,`+presenceColumns+`
+		FROM room_members rm
+		INNER JOIN users u ON rm.user_id = u.id
+		WHERE rm.room_id = ANY($1) AND rm.user_id <> $2
+		ORDER BY u.username
+	`, pq.Array(roomIDs), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer memberRows.Close()
+
+	for memberRows.Next() {
+		var roomID int
+		user := &models.User{}
+		dest := []interface{}{
+			&roomID, &user.ID, &user.Username, &user.Email, &user.AvatarURL,
+			&user.CreatedAt, &user.UpdatedAt,
+		}
+		err := memberRows.Scan(append(dest, scanPresence(user)...)...)
+		if err != nil {
+			return nil, err
+		}
+		if dm, ok := byRoom[roomID]; ok {
+			dm.Participants = append(dm.Participants, user)
+		}
+	}
+	return dms, nil
+}
+
+func (r *RoomRepository) GetUserDMRoomIDs(userID int) ([]int, error) {
+	query := `
+		SELECT r.id FROM rooms r
+		INNER JOIN room_members rm ON r.id = rm.room_id
+		WHERE rm.user_id = $1 AND r.room_type = 'dm'
+	`
+	return r.queryIDs(query, userID)
+}
@@ -0,0 +1,31 @@
+package repository
+
+import "testing"
+
+func TestDMKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		userIDs []int
+		want    string
+	}{
+		{"pair", []int{1, 2}, "1,2"},
+		{"pair reversed", []int{2, 1}, "1,2"},
+		{"group", []int{30, 4, 12}, "4,12,30"},
+		{"numeric not lexical order", []int{10, 9}, "9,10"},
+		{"single", []int{7}, "7"},
+	}
+
+	for _, tt := range tests {
+		if got := dmKey(tt.userIDs); got != tt.want {
+			t.Errorf("%s: dmKey(%v) = %q, want %q", tt.name, tt.userIDs, got, tt.want)
+		}
+	}
+}
+
+func TestDMKeyLeavesInputUnsorted(t *testing.T) {
+	userIDs := []int{3, 1, 2}
+	dmKey(userIDs)
+	if userIDs[0] != 3 || userIDs[1] != 1 || userIDs[2] != 2 {
+		t.Errorf("dmKey reordered its input: %v", userIDs)
+	}
+}
@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"real-time-chat/internal/models"
+	"time"
+)
+
+var (
+	ErrInvitationNotFound  = errors.New("invitation not found")
+	ErrInvitationClosed    = errors.New("invitation is no longer pending")
+	ErrInvitationExpired   = errors.New("invitation has expired")
+	ErrInvitationExhausted = errors.New("invitation has reached its maximum uses")
+)
+
+type InvitationRepository struct {
+	db *sql.DB
+}
+
+func NewInvitationRepository(db *sql.DB) *InvitationRepository {
+	return &InvitationRepository{db: db}
+}
+
+const invitationColumns = `
+	i.id, i.room_id, r.name, COALESCE(i.inviter_id, 0), COALESCE(u.username, 'Deleted User'),
+	i.invitee_id, COALESCE(i.token, ''), i.max_uses, i.use_count, i.expires_at, i.status, i.created_at
+`
+
+const invitationJoins = `
+	FROM room_invitations i
+	INNER JOIN rooms r ON i.room_id = r.id
+	LEFT JOIN users u ON i.inviter_id = u.id
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanInvitation(row rowScanner) (*models.Invitation, error) {
+	inv := &models.Invitation{}
+	err := row.Scan(
+		&inv.ID, &inv.RoomID, &inv.RoomName, &inv.InviterID, &inv.InviterName,
+		&inv.InviteeID, &inv.Token, &inv.MaxUses, &inv.UseCount, &inv.ExpiresAt, &inv.Status, &inv.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (r *InvitationRepository) Create(inv *models.Invitation) error {
+	var token interface{}
+	if inv.Token != "" {
+		token = inv.Token
+	}
+
+	query := `
+		INSERT INTO room_invitations (room_id, inviter_id, invitee_id, token, max_uses, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, status, use_count, created_at
+	`
+	return r.db.QueryRow(query, inv.RoomID, inv.InviterID, inv.InviteeID, token, inv.MaxUses, inv.ExpiresAt).
+		Scan(&inv.ID, &inv.Status, &inv.UseCount, &inv.CreatedAt)
+}
+
+func (r *InvitationRepository) GetByID(id int) (*models.Invitation, error) {
+	query := `SELECT ` + invitationColumns + invitationJoins + ` WHERE i.id = $1`
+	inv, err := scanInvitation(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	}
+	return inv, err
+}
+
+func (r *InvitationRepository) GetPendingForUser(userID int) ([]*models.Invitation, error) {
+	query := `SELECT ` + invitationColumns + invitationJoins + `
+		WHERE i.invitee_id = $1 AND i.status = 'pending'
+		  AND (i.expires_at IS NULL OR i.expires_at > CURRENT_TIMESTAMP)
+		ORDER BY i.created_at DESC
+	`
+	return r.query(query, userID)
+}
+
+// GetByRoomID lists a room's invitations that can still be used, leaving
+// out expired and used-up ones.
+func (r *InvitationRepository) GetByRoomID(roomID int) ([]*models.Invitation, error) {
+	query := `SELECT ` + invitationColumns + invitationJoins + `
+		WHERE i.room_id = $1 AND i.status = 'pending'
+		  AND (i.expires_at IS NULL OR i.expires_at > CURRENT_TIMESTAMP)
+		  AND (i.max_uses IS NULL OR i.use_count < i.max_uses)
+		ORDER BY i.created_at DESC
+	`
+	return r.query(query, roomID)
+}
+
+func (r *InvitationRepository) query(query string, args ...interface{}) ([]*models.Invitation, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*models.Invitation
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, nil
+}
+
+// Accept marks a direct invitation as accepted and adds the invitee to the
+// room in one transaction.
+func (r *InvitationRepository) Accept(id, userID int) (*models.Invitation, error) {
+	return r.redeem(`i.id = $1 AND i.invitee_id = $2`, []interface{}{id, userID}, userID, true)
+}
+
+// RedeemLink adds the user to the room behind a shareable invite link,
+// counting the use against the link's limit unless they were already a
+// member.
+func (r *InvitationRepository) RedeemLink(token string, userID int) (*models.Invitation, error) {
+	return r.redeem(`i.token = $1`, []interface{}{token}, userID, false)
+}
+
+func (r *InvitationRepository) redeem(where string, args []interface{}, userID int, closeOnUse bool) (*models.Invitation, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + invitationColumns + invitationJoins + ` WHERE ` + where + ` FOR UPDATE OF i`
+	inv, err := scanInvitation(tx.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if inv.Status != models.InvitationPending {
+		return nil, ErrInvitationClosed
+	}
+	if inv.ExpiresAt != nil && time.Now().After(*inv.ExpiresAt) {
+		return nil, ErrInvitationExpired
+	}
+	if inv.MaxUses != nil && inv.UseCount >= *inv.MaxUses {
+		return nil, ErrInvitationExhausted
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO room_members (room_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT (room_id, user_id) DO NOTHING
+	`, inv.RoomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	joined, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	status := inv.Status
+	if closeOnUse {
+		status = models.InvitationAccepted
+	}
+	if _, err := tx.Exec(
+		`UPDATE room_invitations SET use_count = use_count + $1, status = $2 WHERE id = $3`,
+		joined, status, inv.ID,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	inv.UseCount += int(joined)
+	inv.Status = status
+	return inv, nil
+}
+
+// SetStatus closes a pending invitation. It reports ErrInvitationClosed if
+// the invitation was already accepted, declined or revoked.
+func (r *InvitationRepository) SetStatus(id int, status string) error {
+	query := `UPDATE room_invitations SET status = $1 WHERE id = $2 AND status = 'pending'`
+	result, err := r.db.Exec(query, status, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInvitationClosed
+	}
+	return nil
+}
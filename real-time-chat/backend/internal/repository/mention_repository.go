@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"database/sql"
+	"real-time-chat/internal/models"
+)
+
+type MentionRepository struct {
+	db *sql.DB
+}
+
+func NewMentionRepository(db *sql.DB) *MentionRepository {
+	return &MentionRepository{db: db}
+}
+
+// Create stores one mention per recipient; mentionTypes maps user ID to how
+// they were mentioned.
+func (r *MentionRepository) Create(messageID int, mentionTypes map[int]string) error {
+	if len(mentionTypes) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO message_mentions (message_id, user_id, mention_type)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (message_id, user_id) DO NOTHING
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for userID, mentionType := range mentionTypes {
+		if _, err := stmt.Exec(messageID, userID, mentionType); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetForUser returns a page of the user's mentions, newest first. The page
+// size is clamped like room history.
+func (r *MentionRepository) GetForUser(userID, limit, offset int) ([]*models.Mention, error) {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	query := `
+		SELECT mm.id, m.id, m.room_id, r.name, mm.mention_type,
+		       COALESCE(m.user_id, 0), COALESCE(u.username, 'Deleted User'), m.content, mm.created_at
+		FROM message_mentions mm
+		INNER JOIN messages m ON mm.message_id = m.id
+		INNER JOIN rooms r ON m.room_id = r.id
+		INNER JOIN room_members rm ON rm.room_id = r.id AND rm.user_id = mm.user_id
+		LEFT JOIN users u ON m.user_id = u.id
+		WHERE mm.user_id = $1 AND m.deleted_at IS NULL
+		ORDER BY mm.created_at DESC, mm.id DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := r.db.Query(query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mentions []*models.Mention
+	for rows.Next() {
+		mention := &models.Mention{}
+		err := rows.Scan(
+			&mention.ID, &mention.MessageID, &mention.RoomID, &mention.RoomName, &mention.MentionType,
+			&mention.AuthorID, &mention.AuthorName, &mention.Content, &mention.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, mention)
+	}
+	return mentions, nil
+}
@@ -2,9 +2,47 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"real-time-chat/internal/models"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrMessageDeleted   = errors.New("message has been deleted")
+	ErrInvalidParent    = errors.New("replies must target a top-level message in the same room")
+	ErrDuplicateMessage = errors.New("message with this client_msg_id already exists")
 )
 
+// messageColumns selects a message with its author's name. Deleted messages
+// come back as tombstones: the row keeps its place in the history but its
+// content is never returned to clients.
+const messageColumns = `
+	m.id, m.room_id, COALESCE(m.user_id, 0), COALESCE(u.username, 'Deleted User') as username,
+	CASE WHEN m.deleted_at IS NULL THEN m.content ELSE '' END,
+	m.message_type, m.created_at, m.edited_at, m.deleted_at, COALESCE(m.deleted_by, 0),
+	m.parent_id, COALESCE(m.reply_count, 0), m.last_reply_at, COALESCE(m.seq, 0),
+	m.client_msg_id
+`
+
+func scanMessage(row rowScanner) (*models.Message, error) {
+	message := &models.Message{}
+	err := row.Scan(
+		&message.ID, &message.RoomID, &message.UserID, &message.Username,
+		&message.Content, &message.MessageType, &message.CreatedAt, &message.EditedAt,
+		&message.DeletedAt, &message.DeletedBy,
+		&message.ParentID, &message.ReplyCount, &message.LastReplyAt, &message.Seq,
+		&message.ClientMsgID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	message.IsDeleted = message.DeletedAt != nil
+	return message, nil
+}
+
 type MessageRepository struct {
 	db *sql.DB
 }
@@ -13,59 +51,545 @@ func NewMessageRepository(db *sql.DB) *MessageRepository {
 	return &MessageRepository{db: db}
 }
 
+// Create stores a message and assigns it the next sequence number of its
+// room. The room row is locked while the number is taken, so sequence order
+// matches commit order even with several server instances writing.
+// A message whose ClientMsgID the author already used is not stored again;
+// ErrDuplicateMessage is returned instead.
 func (r *MessageRepository) Create(message *models.Message) error {
-	query := `
-		INSERT INTO messages (room_id, user_id, content, message_type)
-		VALUES ($1, $2, $3, $4)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if message.ParentID != nil {
+		if err := checkReplyParent(tx, message); err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRow(
+		`UPDATE rooms SET last_seq = last_seq + 1 WHERE id = $1 RETURNING last_seq`,
+		message.RoomID,
+	).Scan(&message.Seq)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO messages (room_id, user_id, content, message_type, parent_id, seq, client_msg_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id, client_msg_id) WHERE client_msg_id IS NOT NULL DO NOTHING
 		RETURNING id, created_at
-	`
-	return r.db.QueryRow(query, message.RoomID, message.UserID, message.Content, message.MessageType).
+	`, message.RoomID, message.UserID, message.Content, message.MessageType, message.ParentID, message.Seq,
+		message.ClientMsgID).
 		Scan(&message.ID, &message.CreatedAt)
+	if err == sql.ErrNoRows {
+		return ErrDuplicateMessage
+	}
+	if err != nil {
+		return err
+	}
+
+	// Replies bump their parent's counters in the same transaction
+	if message.ParentID != nil {
+		if _, err := tx.Exec(
+			`UPDATE messages SET reply_count = reply_count + 1, last_reply_at = $1 WHERE id = $2`,
+			message.CreatedAt, *message.ParentID,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// checkReplyParent locks the parent of a reply and verifies it is a live
+// top-level message in the same room. Threads are one level deep.
+func checkReplyParent(tx *sql.Tx, message *models.Message) error {
+	var parentRoomID int
+	var parentOfParent sql.NullInt64
+	var deletedAt sql.NullTime
+	err := tx.QueryRow(
+		`SELECT room_id, parent_id, deleted_at FROM messages WHERE id = $1 FOR UPDATE`,
+		*message.ParentID,
+	).Scan(&parentRoomID, &parentOfParent, &deletedAt)
+	if err == sql.ErrNoRows {
+		return ErrInvalidParent
+	}
+	if err != nil {
+		return err
+	}
+	if parentRoomID != message.RoomID || parentOfParent.Valid {
+		return ErrInvalidParent
+	}
+	if deletedAt.Valid {
+		return ErrMessageDeleted
+	}
+	return nil
 }
 
-func (r *MessageRepository) GetByRoomID(roomID, limit, offset int) ([]*models.Message, error) {
+// GetThread returns a top-level message and all of its replies in
+// chronological order.
+func (r *MessageRepository) GetThread(parentID, viewerID int) (*models.Thread, error) {
+	parent, err := r.GetByID(parentID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.ParentID != nil {
+		return nil, ErrInvalidParent
+	}
+
 	query := `
-		SELECT m.id, m.room_id, m.user_id, COALESCE(u.username, 'Deleted User') as username, 
-		       m.content, m.message_type, m.created_at
+		SELECT ` + messageColumns + `
 		FROM messages m
 		LEFT JOIN users u ON m.user_id = u.id
-		WHERE m.room_id = $1
-		ORDER BY m.created_at DESC
-		LIMIT $2 OFFSET $3
+		WHERE m.parent_id = $1
+		ORDER BY m.seq ASC
 	`
-	rows, err := r.db.Query(query, roomID, limit, offset)
+	rows, err := r.db.Query(query, parentID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []*models.Message
+	replies := []*models.Message{}
 	for rows.Next() {
-		message := &models.Message{}
-		err := rows.Scan(
-			&message.ID, &message.RoomID, &message.UserID, &message.Username,
-			&message.Content, &message.MessageType, &message.CreatedAt,
-		)
+		message, err := scanMessage(rows)
 		if err != nil {
 			return nil, err
 		}
-		messages = append(messages, message)
+		replies = append(replies, message)
+	}
+
+	all := append([]*models.Message{parent}, replies...)
+	if err := r.loadReactions(all, viewerID); err != nil {
+		return nil, err
+	}
+
+	return &models.Thread{Parent: parent, Replies: replies}, nil
+}
+
+// seqOfArg resolves the message ID passed as $2 to its sequence number in
+// the room passed as $1. Unknown IDs resolve to 0, the position before the
+// first message.
+const seqOfArg = `COALESCE((SELECT seq FROM messages WHERE id = $2 AND room_id = $1), 0)`
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// GetByRoomID returns a page of top-level room history using keyset
+// pagination on the room's sequence number, so pages stay stable while new
+// messages arrive. Cursors are expressed as message IDs.
+// Reactions are aggregated from the point of view of viewerID. Thread
+// replies are fetched separately through GetThread unless q.IncludeReplies
+// is set.
+func (r *MessageRepository) GetByRoomID(roomID, viewerID int, q models.MessageQuery) (*models.MessagePage, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	var messages []*models.Message
+	var hasOlder, hasNewer bool
+
+	list := r.listRoomMessages
+	if q.IncludeReplies {
+		list = r.listAllMessages
 	}
 
-	// Reverse to get chronological order
+	switch {
+	case q.AroundID > 0:
+		half := limit / 2
+		older, err := list(roomID, "m.seq < "+seqOfArg, "DESC", half+1, q.AroundID)
+		if err != nil {
+			return nil, err
+		}
+		newer, err := list(roomID, "m.seq >= "+seqOfArg, "ASC", limit-half+1, q.AroundID)
+		if err != nil {
+			return nil, err
+		}
+
+		hasOlder, older = trimPage(older, half)
+		hasNewer, newer = trimPage(newer, limit-half)
+		reverseMessages(older)
+		messages = append(older, newer...)
+
+	case q.BeforeID > 0:
+		page, err := list(roomID, "m.seq < "+seqOfArg, "DESC", limit+1, q.BeforeID)
+		if err != nil {
+			return nil, err
+		}
+		hasOlder, messages = trimPage(page, limit)
+		reverseMessages(messages)
+		hasNewer = true
+
+	case q.AfterID > 0:
+		page, err := list(roomID, "m.seq > "+seqOfArg, "ASC", limit+1, q.AfterID)
+		if err != nil {
+			return nil, err
+		}
+		hasNewer, messages = trimPage(page, limit)
+		hasOlder = true
+
+	default:
+		page, err := list(roomID, "", "DESC", limit+1)
+		if err != nil {
+			return nil, err
+		}
+		hasOlder, messages = trimPage(page, limit)
+		reverseMessages(messages)
+	}
+
+	if err := r.loadReactions(messages, viewerID); err != nil {
+		return nil, err
+	}
+
+	return newMessagePage(messages, hasOlder, hasNewer, q.IncludeReplies), nil
+}
+
+// newMessagePage wraps an oldest-first page of messages with cursors to the
+// pages on either side, where more messages exist. The cursors keep
+// includeReplies so following them stays in the same view.
+func newMessagePage(messages []*models.Message, hasOlder, hasNewer, includeReplies bool) *models.MessagePage {
+	result := &models.MessagePage{Messages: messages}
+	if result.Messages == nil {
+		result.Messages = []*models.Message{}
+	}
+	if len(messages) > 0 {
+		if hasOlder {
+			result.Prev = &models.MessageCursor{BeforeID: messages[0].ID, IncludeReplies: includeReplies}
+		}
+		if hasNewer {
+			result.Next = &models.MessageCursor{AfterID: messages[len(messages)-1].ID, IncludeReplies: includeReplies}
+		}
+	}
+	return result
+}
+
+// listRoomMessages fetches top-level messages of a room in sequence order.
+// cond may reference the extra args starting at $2.
+func (r *MessageRepository) listRoomMessages(roomID int, cond, order string, limit int, args ...interface{}) ([]*models.Message, error) {
+	return r.listMessages(roomID, "m.parent_id IS NULL", cond, order, limit, args...)
+}
+
+// listAllMessages is listRoomMessages with thread replies included.
+func (r *MessageRepository) listAllMessages(roomID int, cond, order string, limit int, args ...interface{}) ([]*models.Message, error) {
+	return r.listMessages(roomID, "", cond, order, limit, args...)
+}
+
+func (r *MessageRepository) listMessages(roomID int, scope, cond, order string, limit int, args ...interface{}) ([]*models.Message, error) {
+	where := "m.room_id = $1"
+	for _, c := range []string{scope, cond} {
+		if c != "" {
+			where += " AND " + c
+		}
+	}
+
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		LEFT JOIN users u ON m.user_id = u.id
+		WHERE ` + where + `
+		ORDER BY m.seq ` + order + `
+		LIMIT ` + strconv.Itoa(limit)
+
+	return r.queryMessages(query, append([]interface{}{roomID}, args...)...)
+}
+
+// trimPage drops the look-ahead row used to detect whether more messages
+// exist beyond the page.
+func trimPage(messages []*models.Message, limit int) (bool, []*models.Message) {
+	if len(messages) > limit {
+		return true, messages[:limit]
+	}
+	return false, messages
+}
+
+func reverseMessages(messages []*models.Message) {
 	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
 		messages[i], messages[j] = messages[j], messages[i]
 	}
+}
+
+// GetSince returns every message in the room sequenced after afterID,
+// thread replies included, oldest first. At most limit rows are returned.
+func (r *MessageRepository) GetSince(roomID, afterID, limit int) ([]*models.Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		LEFT JOIN users u ON m.user_id = u.id
+		WHERE m.room_id = $1 AND m.seq > ` + seqOfArg + `
+		ORDER BY m.seq ASC
+		LIMIT $3
+	`
+	return r.queryMessages(query, roomID, afterID, limit)
+}
+
+// GetChangedSince returns messages up to and including lastID that were
+// edited or deleted after lastID was posted, i.e. changes a client that had
+// seen lastID may have missed.
+func (r *MessageRepository) GetChangedSince(roomID, lastID, limit int) ([]*models.Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		LEFT JOIN users u ON m.user_id = u.id
+		WHERE m.room_id = $1 AND m.seq <= ` + seqOfArg + `
+		  AND GREATEST(m.edited_at, m.deleted_at) >= (SELECT created_at FROM messages WHERE id = $2)
+		ORDER BY GREATEST(m.edited_at, m.deleted_at) ASC, m.seq ASC
+		LIMIT $3
+	`
+	return r.queryMessages(query, roomID, lastID, limit)
+}
+
+func (r *MessageRepository) queryMessages(query string, args ...interface{}) ([]*models.Message, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
+	var messages []*models.Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
 	return messages, nil
 }
 
-func (r *MessageRepository) GetLatestByRoomID(roomID, limit int) ([]*models.Message, error) {
-	return r.GetByRoomID(roomID, limit, 0)
+func (r *MessageRepository) loadReactions(messages []*models.Message, viewerID int) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*models.Message, len(messages))
+	ids := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		byID[message.ID] = message
+		ids = append(ids, int64(message.ID))
+	}
+
+	query := `
+		SELECT message_id, emoji, COUNT(*), BOOL_OR(user_id = $2)
+		FROM message_reactions
+		WHERE message_id = ANY($1)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at)
+	`
+	rows, err := r.db.Query(query, pq.Array(ids), viewerID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var reaction models.Reaction
+		if err := rows.Scan(&messageID, &reaction.Emoji, &reaction.Count, &reaction.ReactedByMe); err != nil {
+			return err
+		}
+		if message, ok := byID[messageID]; ok {
+			message.Reactions = append(message.Reactions, reaction)
+		}
+	}
+	return rows.Err()
+}
+
+// AddReaction records a user's reaction and reports whether it was new.
+func (r *MessageRepository) AddReaction(messageID, userID int, emoji string) (bool, error) {
+	query := `
+		INSERT INTO message_reactions (message_id, user_id, emoji)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (message_id, user_id, emoji) DO NOTHING
+	`
+	result, err := r.db.Exec(query, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// RemoveReaction deletes a user's reaction and reports whether one existed.
+func (r *MessageRepository) RemoveReaction(messageID, userID int, emoji string) (bool, error) {
+	query := `DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`
+	result, err := r.db.Exec(query, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (r *MessageRepository) CountReactions(messageID int, emoji string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM message_reactions WHERE message_id = $1 AND emoji = $2`
+	err := r.db.QueryRow(query, messageID, emoji).Scan(&count)
+	return count, err
+}
+
+func (r *MessageRepository) GetByID(id int) (*models.Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		LEFT JOIN users u ON m.user_id = u.id
+		WHERE m.id = $1
+	`
+	message, err := scanMessage(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// GetByClientMsgID returns the message a user sent with the given client
+// message ID.
+func (r *MessageRepository) GetByClientMsgID(userID int, clientMsgID string) (*models.Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		LEFT JOIN users u ON m.user_id = u.id
+		WHERE m.user_id = $1 AND m.client_msg_id = $2
+	`
+	message, err := scanMessage(r.db.QueryRow(query, userID, clientMsgID))
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// Edit replaces a message's content, archiving the previous revision in
+// message_edits within the same transaction.
+func (r *MessageRepository) Edit(message *models.Message, content string, editedBy int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	var deletedAt sql.NullTime
+	err = tx.QueryRow(`SELECT content, deleted_at FROM messages WHERE id = $1 FOR UPDATE`, message.ID).
+		Scan(&previous, &deletedAt)
+	if err == sql.ErrNoRows {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if deletedAt.Valid {
+		return ErrMessageDeleted
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO message_edits (message_id, content, edited_by) VALUES ($1, $2, $3)`,
+		message.ID, previous, editedBy,
+	); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(
+		`UPDATE messages SET content = $1, edited_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING edited_at`,
+		content, message.ID,
+	).Scan(&message.EditedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	message.Content = content
+	return nil
+}
+
+func (r *MessageRepository) GetEditHistory(messageID int) ([]*models.MessageEdit, error) {
+	query := `
+		SELECT id, message_id, content, COALESCE(edited_by, 0), edited_at
+		FROM message_edits
+		WHERE message_id = $1
+		ORDER BY edited_at ASC, id ASC
+	`
+	rows, err := r.db.Query(query, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var edits []*models.MessageEdit
+	for rows.Next() {
+		edit := &models.MessageEdit{}
+		if err := rows.Scan(&edit.ID, &edit.MessageID, &edit.Content, &edit.EditedBy, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+	return edits, nil
 }
 
-func (r *MessageRepository) Delete(id int) error {
-	query := `DELETE FROM messages WHERE id = $1`
-	_, err := r.db.Exec(query, id)
-	return err
+// Delete soft-deletes a message, leaving a tombstone in the room history.
+// Deleting a reply takes it off its parent's counters, and the parent is
+// returned with its updated counters; it is nil for top-level messages.
+func (r *MessageRepository) Delete(message *models.Message, deletedBy int) (*models.Message, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE messages SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $1
+		WHERE id = $2 AND deleted_at IS NULL
+		RETURNING deleted_at
+	`
+	err = tx.QueryRow(query, deletedBy, message.ID).Scan(&message.DeletedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageDeleted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var parent *models.Message
+	if message.ParentID != nil {
+		parent = &models.Message{ID: *message.ParentID, RoomID: message.RoomID}
+		err := tx.QueryRow(`
+			UPDATE messages p SET
+				reply_count = GREATEST(COALESCE(p.reply_count, 0) - 1, 0),
+				last_reply_at = (
+					SELECT MAX(created_at) FROM messages
+					WHERE parent_id = p.id AND deleted_at IS NULL
+				)
+			WHERE p.id = $1
+			RETURNING p.reply_count, p.last_reply_at
+		`, *message.ParentID).Scan(&parent.ReplyCount, &parent.LastReplyAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	message.Content = ""
+	message.DeletedBy = deletedBy
+	message.IsDeleted = true
+	return parent, nil
 }
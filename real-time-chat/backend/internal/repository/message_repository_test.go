@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"real-time-chat/internal/models"
+	"reflect"
+	"testing"
+)
+
+func messagesWithIDs(ids ...int) []*models.Message {
+	messages := make([]*models.Message, len(ids))
+	for i, id := range ids {
+		messages[i] = &models.Message{ID: id}
+	}
+	return messages
+}
+
+func messageIDs(messages []*models.Message) []int {
+	ids := []int{}
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestTrimPage(t *testing.T) {
+	tests := []struct {
+		name     string
+		ids      []int
+		limit    int
+		wantMore bool
+		wantIDs  []int
+	}{
+		{"empty", nil, 3, false, []int{}},
+		{"short page", []int{1, 2}, 3, false, []int{1, 2}},
+		{"exactly full", []int{1, 2, 3}, 3, false, []int{1, 2, 3}},
+		{"look-ahead row", []int{1, 2, 3, 4}, 3, true, []int{1, 2, 3}},
+		{"zero limit", []int{1}, 0, true, []int{}},
+	}
+
+	for _, tt := range tests {
+		more, page := trimPage(messagesWithIDs(tt.ids...), tt.limit)
+		if more != tt.wantMore || !reflect.DeepEqual(messageIDs(page), tt.wantIDs) {
+			t.Errorf("%s: trimPage = %v, %v; want %v, %v", tt.name, more, messageIDs(page), tt.wantMore, tt.wantIDs)
+		}
+	}
+}
+
+func TestReverseMessages(t *testing.T) {
+	tests := []struct {
+		ids  []int
+		want []int
+	}{
+		{nil, []int{}},
+		{[]int{1}, []int{1}},
+		{[]int{1, 2}, []int{2, 1}},
+		{[]int{3, 2, 1}, []int{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		messages := messagesWithIDs(tt.ids...)
+		reverseMessages(messages)
+		if got := messageIDs(messages); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("reverseMessages(%v) = %v, want %v", tt.ids, got, tt.want)
+		}
+	}
+}
+
+func TestNewMessagePage(t *testing.T) {
+	tests := []struct {
+		name     string
+		ids      []int
+		hasOlder bool
+		hasNewer bool
+		replies  bool
+		wantPrev *models.MessageCursor
+		wantNext *models.MessageCursor
+	}{
+		{"only page", []int{4, 5, 6}, false, false, false, nil, nil},
+		{"latest page", []int{4, 5, 6}, true, false, false, &models.MessageCursor{BeforeID: 4}, nil},
+		{"oldest page", []int{4, 5, 6}, false, true, false, nil, &models.MessageCursor{AfterID: 6}},
+		{"middle page", []int{4, 5, 6}, true, true, false, &models.MessageCursor{BeforeID: 4}, &models.MessageCursor{AfterID: 6}},
+		{"empty page has no cursors", nil, true, true, false, nil, nil},
+		{"cursors keep replies", []int{4, 7}, true, true, true,
+			&models.MessageCursor{BeforeID: 4, IncludeReplies: true}, &models.MessageCursor{AfterID: 7, IncludeReplies: true}},
+	}
+
+	for _, tt := range tests {
+		page := newMessagePage(messagesWithIDs(tt.ids...), tt.hasOlder, tt.hasNewer, tt.replies)
+		if page.Messages == nil {
+			t.Errorf("%s: Messages is nil, want an empty slice", tt.name)
+		}
+		if !reflect.DeepEqual(page.Prev, tt.wantPrev) {
+			t.Errorf("%s: Prev = %+v, want %+v", tt.name, page.Prev, tt.wantPrev)
+		}
+		if !reflect.DeepEqual(page.Next, tt.wantNext) {
+			t.Errorf("%s: Next = %+v, want %+v", tt.name, page.Next, tt.wantNext)
+		}
+	}
+}
@@ -3,6 +3,9 @@ package repository
 import (
 	"database/sql"
 	"real-time-chat/internal/models"
+	"strings"
+
+	"github.com/lib/pq"
 )
 
 type RoomRepository struct {
@@ -13,24 +16,12 @@ func NewRoomRepository(db *sql.DB) *RoomRepository {
 	return &RoomRepository{db: db}
 }
 
-func (r *RoomRepository) Create(room *models.Room) error {
-	query := `
-		INSERT INTO rooms (name, description, created_by, is_private)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at
-	`
-	return r.db.QueryRow(query, room.Name, room.Description, room.CreatedBy, room.IsPrivate).
-		Scan(&room.ID, &room.CreatedAt)
-}
+const roomColumns = `r.id, r.name, r.description, r.created_by, r.is_private, r.room_type, r.created_at`
 
-func (r *RoomRepository) GetByID(id int) (*models.Room, error) {
+func scanRoom(row rowScanner) (*models.Room, error) {
 	room := &models.Room{}
-	query := `
-		SELECT id, name, description, created_by, is_private, created_at
-		FROM rooms WHERE id = $1
-	`
-	err := r.db.QueryRow(query, id).Scan(
-		&room.ID, &room.Name, &room.Description, &room.CreatedBy, &room.IsPrivate, &room.CreatedAt,
+	err := row.Scan(
+		&room.ID, &room.Name, &room.Description, &room.CreatedBy, &room.IsPrivate, &room.Type, &room.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -38,13 +29,8 @@ func (r *RoomRepository) GetByID(id int) (*models.Room, error) {
 	return room, nil
 }
 
-func (r *RoomRepository) GetAll() ([]*models.Room, error) {
-	query := `
-		SELECT id, name, description, created_by, is_private, created_at
-		FROM rooms WHERE is_private = false
-		ORDER BY created_at DESC
-	`
-	rows, err := r.db.Query(query)
+func (r *RoomRepository) queryRooms(query string, args ...interface{}) ([]*models.Room, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -52,10 +38,7 @@ func (r *RoomRepository) GetAll() ([]*models.Room, error) {
 
 	var rooms []*models.Room
 	for rows.Next() {
-		room := &models.Room{}
-		err := rows.Scan(
-			&room.ID, &room.Name, &room.Description, &room.CreatedBy, &room.IsPrivate, &room.CreatedAt,
-		)
+		room, err := scanRoom(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -64,12 +47,51 @@ func (r *RoomRepository) GetAll() ([]*models.Room, error) {
 	return rooms, nil
 }
 
-func (r *RoomRepository) GetUserRooms(userID int) ([]*models.Room, error) {
+func (r *RoomRepository) Create(room *models.Room) error {
 	query := `
-		SELECT r.id, r.name, r.description, r.created_by, r.is_private, r.created_at
+		INSERT INTO rooms (name, description, created_by, is_private)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, room_type, created_at
+	`
+	return r.db.QueryRow(query, room.Name, room.Description, room.CreatedBy, room.IsPrivate).
+		Scan(&room.ID, &room.Type, &room.CreatedAt)
+}
+
+func (r *RoomRepository) GetByID(id int) (*models.Room, error) {
+	query := `SELECT ` + roomColumns + ` FROM rooms r WHERE r.id = $1`
+	return scanRoom(r.db.QueryRow(query, id))
+}
+
+func (r *RoomRepository) Update(room *models.Room) error {
+	query := `
+		UPDATE rooms SET name = $1, description = $2, is_private = $3
+		WHERE id = $4
+	`
+	_, err := r.db.Exec(query, room.Name, room.Description, room.IsPrivate, room.ID)
+	return err
+}
+
+func (r *RoomRepository) GetAll() ([]*models.Room, error) {
+	query := `
+		SELECT ` + roomColumns + `
+		FROM rooms r WHERE r.is_private = false AND r.room_type = 'channel'
+		ORDER BY r.created_at DESC
+	`
+	return r.queryRooms(query)
+}
+
+// GetUserRooms lists the channels a user belongs to, with how many messages
+// from other people arrived since their read marker.
+func (r *RoomRepository) GetUserRooms(userID int) ([]*models.UserRoom, error) {
+	query := `
+		SELECT ` + roomColumns + `, rm.last_read_message_id,
+		       (SELECT COUNT(*) FROM messages m
+		        WHERE m.room_id = r.id AND m.parent_id IS NULL AND m.deleted_at IS NULL
+		          AND m.user_id IS DISTINCT FROM rm.user_id
+		          AND m.seq > COALESCE((SELECT seq FROM messages WHERE id = rm.last_read_message_id), 0))
 		FROM rooms r
 		INNER JOIN room_members rm ON r.id = rm.room_id
-		WHERE rm.user_id = $1
+		WHERE rm.user_id = $1 AND r.room_type = 'channel'
 		ORDER BY r.created_at DESC
 	`
 	rows, err := r.db.Query(query, userID)
@@ -78,11 +100,12 @@ func (r *RoomRepository) GetUserRooms(userID int) ([]*models.Room, error) {
 	}
 	defer rows.Close()
 
-	var rooms []*models.Room
+	var rooms []*models.UserRoom
 	for rows.Next() {
-		room := &models.Room{}
+		room := &models.UserRoom{}
 		err := rows.Scan(
-			&room.ID, &room.Name, &room.Description, &room.CreatedBy, &room.IsPrivate, &room.CreatedAt,
+			&room.ID, &room.Name, &room.Description, &room.CreatedBy, &room.IsPrivate, &room.Type, &room.CreatedAt,
+			&room.LastReadMessageID, &room.UnreadCount,
 		)
 		if err != nil {
 			return nil, err
@@ -92,16 +115,94 @@ func (r *RoomRepository) GetUserRooms(userID int) ([]*models.Room, error) {
 	return rooms, nil
 }
 
+// MarkRead moves the user's read marker forward to messageID. Markers never
+// move backwards, so late or out-of-order frames are harmless. It reports
+// whether the marker changed.
+func (r *RoomRepository) MarkRead(roomID, userID, messageID int) (bool, error) {
+	query := `
+		UPDATE room_members rm SET last_read_message_id = $1
+		WHERE rm.room_id = $2 AND rm.user_id = $3
+		  AND (rm.last_read_message_id IS NULL
+		       OR (SELECT seq FROM messages WHERE id = rm.last_read_message_id) < (SELECT seq FROM messages WHERE id = $1))
+	`
+	result, err := r.db.Exec(query, messageID, roomID, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
 func (r *RoomRepository) AddMember(roomID, userID int) error {
+	return r.AddMemberWithRole(roomID, userID, models.RoleMember)
+}
+
+func (r *RoomRepository) AddMemberWithRole(roomID, userID int, role string) error {
 	query := `
-		INSERT INTO room_members (room_id, user_id)
-		VALUES ($1, $2)
+		INSERT INTO room_members (room_id, user_id, role)
+		VALUES ($1, $2, $3)
 		ON CONFLICT (room_id, user_id) DO NOTHING
 	`
-	_, err := r.db.Exec(query, roomID, userID)
+	_, err := r.db.Exec(query, roomID, userID, role)
 	return err
 }
 
+func (r *RoomRepository) GetMemberRole(roomID, userID int) (string, error) {
+	var role string
+	query := `SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2`
+	err := r.db.QueryRow(query, roomID, userID).Scan(&role)
+	return role, err
+}
+
+func (r *RoomRepository) SetMemberRole(roomID, userID int, role string) error {
+	query := `UPDATE room_members SET role = $1 WHERE room_id = $2 AND user_id = $3`
+	result, err := r.db.Exec(query, role, roomID, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TransferOwnership hands the owner role to another member and demotes the
+// previous owner to admin atomically, so a room never has zero or two owners.
+func (r *RoomRepository) TransferOwnership(roomID, fromUserID, toUserID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE room_members SET role = $1 WHERE room_id = $2 AND user_id = $3`,
+		models.RoleOwner, roomID, toUserID,
+	)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE room_members SET role = $1 WHERE room_id = $2 AND user_id = $3`,
+		models.RoleAdmin, roomID, fromUserID,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (r *RoomRepository) RemoveMember(roomID, userID int) error {
 	query := `DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`
 	_, err := r.db.Exec(query, roomID, userID)
@@ -115,9 +216,10 @@ func (r *RoomRepository) IsMember(roomID, userID int) (bool, error) {
 	return exists, err
 }
 
-func (r *RoomRepository) GetMembers(roomID int) ([]*models.User, error) {
+func (r *RoomRepository) GetMembers(roomID int) ([]*models.Member, error) {
 	query := `
-		SELECT u.id, u.username, u.email, u.avatar_url, u.is_online, u.created_at, u.updated_at
+		SELECT u.id, u.username, u.email, u.avatar_url, u.created_at, u.updated_at,
+		       rm.role, rm.joined_at, rm.last_read_message_id,` + presenceColumns + `
 		FROM users u
 		INNER JOIN room_members rm ON u.id = rm.user_id
 		WHERE rm.room_id = $1
@@ -128,19 +230,59 @@ func (r *RoomRepository) GetMembers(roomID int) ([]*models.User, error) {
 	}
 	defer rows.Close()
 
-	var users []*models.User
+	var members []*models.Member
 	for rows.Next() {
-		user := &models.User{}
-		err := rows.Scan(
-			&user.ID, &user.Username, &user.Email, &user.AvatarURL,
-			&user.IsOnline, &user.CreatedAt, &user.UpdatedAt,
-		)
+		member := &models.Member{}
+		dest := []interface{}{
+			&member.ID, &member.Username, &member.Email, &member.AvatarURL,
+			&member.CreatedAt, &member.UpdatedAt,
+			&member.Role, &member.JoinedAt, &member.LastReadMessageID,
+		}
+		err := rows.Scan(append(dest, scanPresence(&member.User)...)...)
 		if err != nil {
 			return nil, err
 		}
-		users = append(users, user)
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (r *RoomRepository) GetMemberIDs(roomID int) ([]int, error) {
+	return r.queryIDs(`SELECT user_id FROM room_members WHERE room_id = $1`, roomID)
+}
+
+// GetMemberIDsByUsernames resolves usernames case-insensitively, keeping
+// only users who belong to the room.
+func (r *RoomRepository) GetMemberIDsByUsernames(roomID int, usernames []string) ([]int, error) {
+	lowered := make([]string, len(usernames))
+	for i, name := range usernames {
+		lowered[i] = strings.ToLower(name)
+	}
+
+	query := `
+		SELECT u.id FROM users u
+		INNER JOIN room_members rm ON u.id = rm.user_id
+		WHERE rm.room_id = $1 AND LOWER(u.username) = ANY($2)
+	`
+	return r.queryIDs(query, roomID, pq.Array(lowered))
+}
+
+func (r *RoomRepository) queryIDs(query string, args ...interface{}) ([]int, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
 	}
-	return users, nil
+	return ids, nil
 }
 
 func (r *RoomRepository) Delete(id int) error {
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"real-time-chat/internal/models"
+	"time"
+)
+
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrSessionRevoked     = errors.New("session revoked")
+	ErrSessionExpired     = errors.New("session expired")
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+type SessionRepository struct {
+	db *sql.DB
+}
+
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+func (r *SessionRepository) Create(session *models.Session) error {
+	query := `
+		INSERT INTO sessions (family_id, user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(query, session.FamilyID, session.UserID, session.TokenHash, session.ExpiresAt).
+		Scan(&session.ID, &session.CreatedAt)
+}
+
+// Rotate exchanges the refresh token identified by oldHash for a new one in
+// the same family. Presenting a token that was already rotated means it was
+// stolen or replayed, so the whole family is revoked.
+func (r *SessionRepository) Rotate(oldHash, newHash string, expiresAt time.Time) (*models.Session, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	current := &models.Session{}
+	query := `
+		SELECT id, family_id, user_id, token_hash, expires_at, rotated_at, revoked_at, created_at
+		FROM sessions WHERE token_hash = $1
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, oldHash).Scan(
+		&current.ID, &current.FamilyID, &current.UserID, &current.TokenHash,
+		&current.ExpiresAt, &current.RotatedAt, &current.RevokedAt, &current.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if current.RevokedAt != nil {
+		return nil, ErrSessionRevoked
+	}
+
+	if current.RotatedAt != nil {
+		if _, err := tx.Exec(
+			`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = $1 AND revoked_at IS NULL`,
+			current.FamilyID,
+		); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+
+	if _, err := tx.Exec(`UPDATE sessions SET rotated_at = CURRENT_TIMESTAMP WHERE id = $1`, current.ID); err != nil {
+		return nil, err
+	}
+
+	next := &models.Session{
+		FamilyID:  current.FamilyID,
+		UserID:    current.UserID,
+		TokenHash: newHash,
+		ExpiresAt: expiresAt,
+	}
+	err = tx.QueryRow(`
+		INSERT INTO sessions (family_id, user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, next.FamilyID, next.UserID, next.TokenHash, next.ExpiresAt).Scan(&next.ID, &next.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+func (r *SessionRepository) RevokeFamily(familyID string) error {
+	query := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = $1 AND revoked_at IS NULL`
+	_, err := r.db.Exec(query, familyID)
+	return err
+}
+
+func (r *SessionRepository) IsActive(familyID string) (bool, error) {
+	var active bool
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM sessions
+			WHERE family_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		)
+	`
+	err := r.db.QueryRow(query, familyID).Scan(&active)
+	return active, err
+}
@@ -3,8 +3,35 @@ package repository
 import (
 	"database/sql"
 	"real-time-chat/internal/models"
+
+	"github.com/lib/pq"
 )
 
+// presenceColumns selects a user's presence as others see it, in the order
+// of scanPresence. Invisible users appear offline with no custom status,
+// and users who left their status as online show as away while every
+// connection is idle. It is used in queries on users, whether or not they
+// alias the table.
+const presenceColumns = `
+	CASE
+		WHEN NOT is_online OR presence_status = 'invisible' THEN 'offline'
+		WHEN presence_status = 'online' AND is_idle THEN 'away'
+		ELSE presence_status
+	END,
+	is_online AND presence_status <> 'invisible',
+	CASE WHEN presence_status = 'invisible' THEN '' ELSE status_text END,
+	CASE WHEN presence_status = 'invisible' THEN '' ELSE status_emoji END,
+	CASE WHEN presence_status = 'invisible' THEN NULL ELSE status_expires_at END,
+	last_seen_at`
+
+// scanPresence lists the destinations for presenceColumns.
+func scanPresence(user *models.User) []interface{} {
+	return []interface{}{
+		&user.Status, &user.IsOnline,
+		&user.StatusText, &user.StatusEmoji, &user.StatusExpiresAt, &user.LastSeenAt,
+	}
+}
+
 type UserRepository struct {
 	db *sql.DB
 }
@@ -26,13 +53,14 @@ func (r *UserRepository) Create(user *models.User) error {
 func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
 	user := &models.User{}
 	query := `
-		SELECT id, username, email, password_hash, avatar_url, is_online, created_at, updated_at
+		SELECT id, username, email, password_hash, avatar_url, created_at, updated_at,` + presenceColumns + `
 		FROM users WHERE email = $1
 	`
-	err := r.db.QueryRow(query, email).Scan(
+	dest := []interface{}{
 		&user.ID, &user.Username, &user.Email, &user.PasswordHash,
-		&user.AvatarURL, &user.IsOnline, &user.CreatedAt, &user.UpdatedAt,
-	)
+		&user.AvatarURL, &user.CreatedAt, &user.UpdatedAt,
+	}
+	err := r.db.QueryRow(query, email).Scan(append(dest, scanPresence(user)...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -42,13 +70,14 @@ func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
 func (r *UserRepository) GetByID(id int) (*models.User, error) {
 	user := &models.User{}
 	query := `
-		SELECT id, username, email, password_hash, avatar_url, is_online, created_at, updated_at
+		SELECT id, username, email, password_hash, avatar_url, created_at, updated_at,` + presenceColumns + `
 		FROM users WHERE id = $1
 	`
-	err := r.db.QueryRow(query, id).Scan(
+	dest := []interface{}{
 		&user.ID, &user.Username, &user.Email, &user.PasswordHash,
-		&user.AvatarURL, &user.IsOnline, &user.CreatedAt, &user.UpdatedAt,
-	)
+		&user.AvatarURL, &user.CreatedAt, &user.UpdatedAt,
+	}
+	err := r.db.QueryRow(query, id).Scan(append(dest, scanPresence(user)...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -58,29 +87,99 @@ func (r *UserRepository) GetByID(id int) (*models.User, error) {
 func (r *UserRepository) GetByUsername(username string) (*models.User, error) {
 	user := &models.User{}
 	query := `
-		SELECT id, username, email, password_hash, avatar_url, is_online, created_at, updated_at
+		SELECT id, username, email, password_hash, avatar_url, created_at, updated_at,` + presenceColumns + `
 		FROM users WHERE username = $1
 	`
-	err := r.db.QueryRow(query, username).Scan(
+	dest := []interface{}{
 		&user.ID, &user.Username, &user.Email, &user.PasswordHash,
-		&user.AvatarURL, &user.IsOnline, &user.CreatedAt, &user.UpdatedAt,
-	)
+		&user.AvatarURL, &user.CreatedAt, &user.UpdatedAt,
+	}
+	err := r.db.QueryRow(query, username).Scan(append(dest, scanPresence(user)...)...)
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (r *UserRepository) UpdateOnlineStatus(userID int, isOnline bool) error {
-	query := `UPDATE users SET is_online = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
-	_, err := r.db.Exec(query, isOnline, userID)
+// GetStatus returns the presence a user chose and their custom status.
+func (r *UserRepository) GetStatus(userID int) (*models.UserStatus, error) {
+	status := &models.UserStatus{}
+	query := `
+		SELECT presence_status, status_text, status_emoji, status_expires_at
+		FROM users WHERE id = $1
+	`
+	err := r.db.QueryRow(query, userID).Scan(&status.Presence, &status.Text, &status.Emoji, &status.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (r *UserRepository) SetStatus(userID int, status *models.UserStatus) error {
+	query := `
+		UPDATE users SET presence_status = $1, status_text = $2, status_emoji = $3,
+			status_expires_at = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5
+	`
+	_, err := r.db.Exec(query, status.Presence, status.Text, status.Emoji, status.ExpiresAt, userID)
 	return err
 }
 
+// ClearExpiredStatuses removes custom statuses whose expiry has passed and
+// returns the users whose status changed.
+func (r *UserRepository) ClearExpiredStatuses() ([]int, error) {
+	query := `
+		UPDATE users SET status_text = '', status_emoji = '', status_expires_at = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE status_expires_at <= NOW()
+		RETURNING id
+	`
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
+// GetPresences returns the presence of each of the given users that exists,
+// with only their ID and username besides.
+func (r *UserRepository) GetPresences(userIDs []int) ([]*models.User, error) {
+	query := `
+		SELECT id, username,` + presenceColumns + `
+		FROM users WHERE id = ANY($1)
+	`
+	rows, err := r.db.Query(query, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user := &models.User{}
+		dest := []interface{}{&user.ID, &user.Username}
+		if err := rows.Scan(append(dest, scanPresence(user)...)...); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
 func (r *UserRepository) GetOnlineUsers() ([]*models.User, error) {
 	query := `
-		SELECT id, username, email, avatar_url, is_online, created_at, updated_at
-		FROM users WHERE is_online = true
+		SELECT id, username, email, avatar_url, created_at, updated_at,` + presenceColumns + `
+		FROM users WHERE is_online = true AND presence_status <> 'invisible'
 	`
 	rows, err := r.db.Query(query)
 	if err != nil {
@@ -91,10 +190,11 @@ func (r *UserRepository) GetOnlineUsers() ([]*models.User, error) {
 	var users []*models.User
 	for rows.Next() {
 		user := &models.User{}
-		err := rows.Scan(
+		dest := []interface{}{
 			&user.ID, &user.Username, &user.Email, &user.AvatarURL,
-			&user.IsOnline, &user.CreatedAt, &user.UpdatedAt,
-		)
+			&user.CreatedAt, &user.UpdatedAt,
+		}
+		err := rows.Scan(append(dest, scanPresence(user)...)...)
 		if err != nil {
 			return nil, err
 		}
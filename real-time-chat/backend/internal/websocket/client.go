@@ -1,10 +1,14 @@
 package websocket
 
 import (
-	"encoding/json"
+	"errors"
 	"log"
+	"real-time-chat/internal/authz"
+	"real-time-chat/internal/mentions"
 	"real-time-chat/internal/models"
 	"real-time-chat/internal/repository"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,6 +19,10 @@ const (
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
 	maxMessageSize = 4096
+	maxEmojiSize   = 32
+
+	// maxClientMsgIDSize matches the client_msg_id column width
+	maxClientMsgIDSize = 64
 )
 
 type Client struct {
@@ -25,24 +33,113 @@ type Client struct {
 	Username    string
 	messageRepo *repository.MessageRepository
 	roomRepo    *repository.RoomRepository
+	mentionRepo *repository.MentionRepository
+	authorizer  *authz.Authorizer
+
+	// ConnID identifies this connection among the user's devices
+	ConnID string
+	Device string
+
+	// lastActive is when the client last sent a frame, in Unix
+	// nanoseconds; idle is set once it has been quiet for idleAfter
+	lastActive atomic.Int64
+	idle       atomic.Bool
+
+	// Live frames held back while a resume replay is in flight. holdMu
+	// also guards the slow-consumer backlog.
+	holdMu  sync.Mutex
+	holding int
+	held    []*frame
+
+	// Frames waiting for space in send, and when the client fell behind
+	backlog   [][]byte
+	slowSince time.Time
+
+	// done is closed to stop the write pump; send is never closed
+	done        chan struct{}
+	closeOnce   sync.Once
+	closeCode   int
+	closeReason string
+
+	protocol *Protocol
+
+	// Rooms the hub has subscribed this client to, so unregistering only
+	// touches the shards it is actually in
+	roomsMu sync.Mutex
+	rooms   map[int]bool
+	left    bool
 }
 
-func NewClient(hub *Hub, conn *websocket.Conn, userID int, username string, 
-	messageRepo *repository.MessageRepository, roomRepo *repository.RoomRepository) *Client {
-	return &Client{
+func NewClient(hub *Hub, conn *websocket.Conn, userID int, username, device string,
+	messageRepo *repository.MessageRepository, roomRepo *repository.RoomRepository,
+	mentionRepo *repository.MentionRepository, authorizer *authz.Authorizer) *Client {
+	c := &Client{
 		hub:         hub,
 		conn:        conn,
-		send:        make(chan []byte, 256),
+		send:        make(chan []byte, hub.policy.SendBuffer),
+		done:        make(chan struct{}),
 		UserID:      userID,
 		Username:    username,
+		ConnID:      newID(),
+		Device:      device,
 		messageRepo: messageRepo,
 		roomRepo:    roomRepo,
+		mentionRepo: mentionRepo,
+		authorizer:  authorizer,
+		protocol:    protocolFor(conn.Subprotocol()),
+		rooms:       make(map[int]bool),
+	}
+	c.lastActive.Store(time.Now().UnixNano())
+	return c
+}
+
+// addRoom records a room subscription. It reports false once the client
+// has unregistered, so a late join cannot leave it behind in a room.
+func (c *Client) addRoom(roomID int) bool {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	if c.left {
+		return false
+	}
+	c.rooms[roomID] = true
+	return true
+}
+
+func (c *Client) removeRoom(roomID int) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	delete(c.rooms, roomID)
+}
+
+// leaveAllRooms marks the client as gone and returns the rooms it was in.
+func (c *Client) leaveAllRooms() []int {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	c.left = true
+	rooms := make([]int, 0, len(c.rooms))
+	for roomID := range c.rooms {
+		rooms = append(rooms, roomID)
 	}
+	return rooms
+}
+
+// Welcome greets a client that negotiated a protocol with a welcome frame,
+// so it learns which features this server supports. Version 1 clients
+// predate the frame and are not sent one.
+func (c *Client) Welcome() {
+	if c.protocol == protocolV1 {
+		return
+	}
+	c.sendFrame(nil, "welcome", c.protocol.welcome())
+	c.sendFrame(nil, "presence_snapshot", c.hub.presenceSnapshot())
 }
 
 func (c *Client) ReadPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.Unregister(c)
 		c.conn.Close()
 	}()
 
@@ -75,11 +172,20 @@ func (c *Client) WritePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
+		case <-c.done:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeReason))
+			return
+
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+			if !c.protocol.batch {
+				if err := c.conn.WriteMessage(c.protocol.codec.messageType, message); err != nil {
+					return
+				}
+				c.refill()
+				continue
 			}
 
 			w, err := c.conn.NextWriter(websocket.TextMessage)
@@ -98,8 +204,10 @@ func (c *Client) WritePump() {
 			if err := w.Close(); err != nil {
 				return
 			}
+			c.refill()
 
 		case <-ticker.C:
+			c.refill()
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
@@ -109,64 +217,98 @@ func (c *Client) WritePump() {
 }
 
 func (c *Client) handleMessage(data []byte) {
-	var wsMessage models.WSMessage
-	if err := json.Unmarshal(data, &wsMessage); err != nil {
-		log.Printf("Error unmarshaling message: %v", err)
+	var req request
+	if err := c.protocol.codec.decode(data, &req); err != nil {
+		c.sendError(nil, 0, codeBadRequest, "malformed frame")
 		return
 	}
 
-	switch wsMessage.Type {
+	// Any frame shows the user is present; activity frames say so explicitly
+	if req.Type != "activity" {
+		c.markActive()
+	}
+
+	switch req.Type {
+	case "activity":
+		c.handleActivity(&req)
 	case "join_room":
-		c.handleJoinRoom(wsMessage.Payload)
+		c.handleJoinRoom(&req)
+	case "resume":
+		c.handleResume(&req)
 	case "leave_room":
-		c.handleLeaveRoom(wsMessage.Payload)
+		c.handleLeaveRoom(&req)
 	case "send_message":
-		c.handleSendMessage(wsMessage.Payload)
+		c.handleSendMessage(&req)
 	case "typing":
-		c.handleTyping(wsMessage.Payload)
+		c.handleTyping(&req)
+	case "message_edit":
+		c.handleEditMessage(&req)
+	case "delete_message":
+		c.handleDeleteMessage(&req)
+	case "add_reaction":
+		c.handleReaction(&req, true)
+	case "remove_reaction":
+		c.handleReaction(&req, false)
+	case "mark_read":
+		c.handleMarkRead(&req)
+	case "kick_member":
+		c.handleKickMember(&req)
 	default:
-		log.Printf("Unknown message type: %s", wsMessage.Type)
+		c.sendError(&req, 0, codeUnknownType, "unknown message type: "+req.Type)
 	}
 }
 
-func (c *Client) handleJoinRoom(payload interface{}) {
-	data, err := json.Marshal(payload)
-	if err != nil {
+func (c *Client) handleJoinRoom(req *request) {
+	var joinRoom models.JoinRoom
+	if !c.decode(req, &joinRoom) {
 		return
 	}
 
-	var joinRoom models.JoinRoom
-	if err := json.Unmarshal(data, &joinRoom); err != nil {
+	if _, err := c.authorizer.CanJoin(c.UserID, joinRoom.RoomID); err != nil {
+		c.sendFailure(req, joinRoom.RoomID, err, "check permissions")
 		return
 	}
 
 	// Add user to room membership in database
-	c.roomRepo.AddMember(joinRoom.RoomID, c.UserID)
-	c.hub.JoinRoom(c, joinRoom.RoomID)
-}
+	if err := c.roomRepo.AddMember(joinRoom.RoomID, c.UserID); err != nil {
+		c.sendFailure(req, joinRoom.RoomID, err, "join room")
+		return
+	}
 
-func (c *Client) handleLeaveRoom(payload interface{}) {
-	data, err := json.Marshal(payload)
-	if err != nil {
+	if joinRoom.LastMessageID > 0 {
+		c.resumeRooms([]models.ResumeRoom{{RoomID: joinRoom.RoomID, LastMessageID: joinRoom.LastMessageID}}, true)
 		return
 	}
+	c.hub.JoinRoom(c, joinRoom.RoomID)
+}
 
+func (c *Client) handleLeaveRoom(req *request) {
 	var leaveRoom models.LeaveRoom
-	if err := json.Unmarshal(data, &leaveRoom); err != nil {
+	if !c.decode(req, &leaveRoom) {
 		return
 	}
 
 	c.hub.LeaveRoom(c, leaveRoom.RoomID)
 }
 
-func (c *Client) handleSendMessage(payload interface{}) {
-	data, err := json.Marshal(payload)
-	if err != nil {
+func (c *Client) handleSendMessage(req *request) {
+	var chatMessage models.ChatMessage
+	if !c.decode(req, &chatMessage) {
 		return
 	}
 
-	var chatMessage models.ChatMessage
-	if err := json.Unmarshal(data, &chatMessage); err != nil {
+	if chatMessage.Content == "" {
+		c.sendMessageError(req, &chatMessage, codeBadRequest, "content is required")
+		return
+	}
+	if len(chatMessage.ClientMsgID) > maxClientMsgIDSize {
+		c.sendMessageError(req, &chatMessage, codeBadRequest, "client_msg_id is too long")
+		return
+	}
+
+	if err := c.authorizer.CanPost(c.UserID, chatMessage.RoomID); err != nil {
+		code, reason := classifyError(err, "check permissions")
+		c.sendMessageError(req, &chatMessage, code, reason)
 		return
 	}
 
@@ -177,10 +319,37 @@ func (c *Client) handleSendMessage(payload interface{}) {
 		Username:    c.Username,
 		Content:     chatMessage.Content,
 		MessageType: "text",
+		ParentID:    chatMessage.ParentID,
+	}
+	if chatMessage.ClientMsgID != "" {
+		message.ClientMsgID = &chatMessage.ClientMsgID
 	}
 
 	if err := c.messageRepo.Create(message); err != nil {
-		log.Printf("Error saving message: %v", err)
+		if errors.Is(err, repository.ErrDuplicateMessage) {
+			// A retry of a send that already went through: acknowledge the
+			// stored message again without broadcasting it twice
+			message, err = c.messageRepo.GetByClientMsgID(c.UserID, chatMessage.ClientMsgID)
+			if err == nil {
+				c.sendMessageAck(req, &chatMessage, message)
+				return
+			}
+		}
+		code, reason := classifyError(err, "send message")
+		c.sendMessageError(req, &chatMessage, code, reason)
+		return
+	}
+
+	c.sendMessageAck(req, &chatMessage, message)
+	c.notifyMentions(message)
+
+	if message.ParentID != nil {
+		parent, err := c.messageRepo.GetByID(*message.ParentID)
+		if err != nil {
+			log.Printf("Error loading thread parent: %v", err)
+			return
+		}
+		c.hub.BroadcastThreadReply(message, parent)
 		return
 	}
 
@@ -188,16 +357,315 @@ func (c *Client) handleSendMessage(payload interface{}) {
 	c.hub.BroadcastToRoom(chatMessage.RoomID, message)
 }
 
-func (c *Client) handleTyping(payload interface{}) {
-	data, err := json.Marshal(payload)
-	if err != nil {
+// notifyMentions resolves @username, @here and @room against the room's
+// members, stores them and pushes a mention event to each recipient.
+func (c *Client) notifyMentions(message *models.Message) {
+	parsed := mentions.Parse(message.Content)
+	if parsed.Empty() {
+		return
+	}
+
+	recipients := make(map[int]string)
+
+	if parsed.Room || parsed.Here {
+		memberIDs, err := c.roomRepo.GetMemberIDs(message.RoomID)
+		if err != nil {
+			log.Printf("Error loading room members: %v", err)
+			return
+		}
+
+		if parsed.Here {
+			for _, id := range c.hub.ConnectedUsers(memberIDs) {
+				recipients[id] = mentions.TypeHere
+			}
+		}
+		if parsed.Room {
+			for _, id := range memberIDs {
+				recipients[id] = mentions.TypeRoom
+			}
+		}
+	}
+
+	if len(parsed.Usernames) > 0 {
+		userIDs, err := c.roomRepo.GetMemberIDsByUsernames(message.RoomID, parsed.Usernames)
+		if err != nil {
+			log.Printf("Error resolving mentions: %v", err)
+			return
+		}
+		for _, id := range userIDs {
+			recipients[id] = mentions.TypeUser
+		}
+	}
+
+	delete(recipients, c.UserID)
+	if len(recipients) == 0 {
 		return
 	}
 
+	if err := c.mentionRepo.Create(message.ID, recipients); err != nil {
+		log.Printf("Error saving mentions: %v", err)
+		return
+	}
+
+	for userID, mentionType := range recipients {
+		c.hub.SendToUser(userID, models.WSMessage{
+			Type: "mention",
+			Payload: map[string]interface{}{
+				"mention_type": mentionType,
+				"message":      message,
+			},
+		})
+	}
+}
+
+func (c *Client) handleTyping(req *request) {
 	var typing models.TypingIndicator
-	if err := json.Unmarshal(data, &typing); err != nil {
+	if !c.decode(req, &typing) {
+		return
+	}
+
+	// Only clients that passed the join check are in the hub's room set
+	if !c.hub.IsInRoom(c, typing.RoomID) {
 		return
 	}
 
 	c.hub.BroadcastTyping(typing.RoomID, c.UserID, c.Username, typing.IsTyping)
 }
+
+// handleActivity records that the user is at this device, or with idle set
+// that they have stepped away from it. The payload is optional.
+func (c *Client) handleActivity(req *request) {
+	var activity models.Activity
+	if len(req.Payload) > 0 && !c.decode(req, &activity) {
+		return
+	}
+
+	if activity.Idle {
+		c.markIdle()
+	} else {
+		c.markActive()
+	}
+}
+
+func (c *Client) markActive() {
+	c.lastActive.Store(time.Now().UnixNano())
+	if c.idle.CompareAndSwap(true, false) {
+		c.hub.queuePresence(presenceChange{client: c, userID: c.UserID, kind: presenceIdleChanged})
+	}
+}
+
+func (c *Client) markIdle() {
+	if c.idle.CompareAndSwap(false, true) {
+		c.hub.queuePresence(presenceChange{client: c, userID: c.UserID, kind: presenceIdleChanged})
+	}
+}
+
+func (c *Client) handleEditMessage(req *request) {
+	var edit models.EditMessage
+	if !c.decode(req, &edit) {
+		return
+	}
+
+	if edit.Content == "" {
+		c.sendError(req, 0, codeBadRequest, "content is required")
+		return
+	}
+
+	message, err := c.messageRepo.GetByID(edit.MessageID)
+	if err != nil {
+		c.sendFailure(req, 0, err, "load message")
+		return
+	}
+
+	if err := c.authorizer.CanEditMessage(c.UserID, message); err != nil {
+		c.sendFailure(req, message.RoomID, err, "check permissions")
+		return
+	}
+
+	if err := c.messageRepo.Edit(message, edit.Content, c.UserID); err != nil {
+		c.sendFailure(req, message.RoomID, err, "edit message")
+		return
+	}
+
+	c.hub.BroadcastEvent(message.RoomID, models.WSMessage{
+		Type:    "message_updated",
+		Payload: message,
+	})
+}
+
+func (c *Client) handleDeleteMessage(req *request) {
+	var del models.DeleteMessage
+	if !c.decode(req, &del) {
+		return
+	}
+
+	message, err := c.messageRepo.GetByID(del.MessageID)
+	if err != nil {
+		c.sendFailure(req, 0, err, "load message")
+		return
+	}
+
+	if err := c.authorizer.CanDeleteMessage(c.UserID, message); err != nil {
+		c.sendFailure(req, message.RoomID, err, "check permissions")
+		return
+	}
+
+	parent, err := c.messageRepo.Delete(message, c.UserID)
+	if err != nil {
+		c.sendFailure(req, message.RoomID, err, "delete message")
+		return
+	}
+
+	c.hub.BroadcastEvent(message.RoomID, models.WSMessage{
+		Type: "message_deleted",
+		Payload: map[string]interface{}{
+			"message_id": message.ID,
+			"room_id":    message.RoomID,
+			"deleted_by": c.UserID,
+		},
+	})
+	if parent != nil {
+		c.hub.BroadcastThreadUpdated(parent)
+	}
+}
+
+func (c *Client) handleReaction(req *request, add bool) {
+	var reaction models.MessageReaction
+	if !c.decode(req, &reaction) {
+		return
+	}
+
+	if reaction.Emoji == "" || len(reaction.Emoji) > maxEmojiSize {
+		c.sendError(req, 0, codeBadRequest, "invalid emoji")
+		return
+	}
+
+	message, err := c.messageRepo.GetByID(reaction.MessageID)
+	if err != nil {
+		c.sendFailure(req, 0, err, "load message")
+		return
+	}
+
+	if message.IsDeleted {
+		c.sendFailure(req, message.RoomID, repository.ErrMessageDeleted, "update reaction")
+		return
+	}
+
+	if err := c.authorizer.CanPost(c.UserID, message.RoomID); err != nil {
+		c.sendFailure(req, message.RoomID, err, "check permissions")
+		return
+	}
+
+	var changed bool
+	if add {
+		changed, err = c.messageRepo.AddReaction(message.ID, c.UserID, reaction.Emoji)
+	} else {
+		changed, err = c.messageRepo.RemoveReaction(message.ID, c.UserID, reaction.Emoji)
+	}
+	if err != nil {
+		c.sendFailure(req, message.RoomID, err, "update reaction")
+		return
+	}
+	if !changed {
+		return
+	}
+
+	count, err := c.messageRepo.CountReactions(message.ID, reaction.Emoji)
+	if err != nil {
+		log.Printf("Error counting reactions: %v", err)
+		return
+	}
+
+	c.hub.BroadcastEvent(message.RoomID, models.WSMessage{
+		Type: "reaction_updated",
+		Payload: map[string]interface{}{
+			"message_id": message.ID,
+			"room_id":    message.RoomID,
+			"emoji":      reaction.Emoji,
+			"count":      count,
+			"user_id":    c.UserID,
+			"added":      add,
+		},
+	})
+}
+
+func (c *Client) handleMarkRead(req *request) {
+	var markRead models.MarkRead
+	if !c.decode(req, &markRead) {
+		return
+	}
+
+	if err := c.authorizer.CanPost(c.UserID, markRead.RoomID); err != nil {
+		c.sendFailure(req, markRead.RoomID, err, "check permissions")
+		return
+	}
+
+	message, err := c.messageRepo.GetByID(markRead.MessageID)
+	if err == nil && message.RoomID != markRead.RoomID {
+		err = repository.ErrMessageNotFound
+	}
+	if err != nil {
+		c.sendFailure(req, markRead.RoomID, err, "load message")
+		return
+	}
+
+	changed, err := c.roomRepo.MarkRead(markRead.RoomID, c.UserID, markRead.MessageID)
+	if err != nil {
+		c.sendFailure(req, markRead.RoomID, err, "update read marker")
+		return
+	}
+	if !changed {
+		return
+	}
+
+	c.hub.BroadcastEvent(markRead.RoomID, models.WSMessage{
+		Type: "read_receipt",
+		Payload: map[string]interface{}{
+			"room_id":    markRead.RoomID,
+			"user_id":    c.UserID,
+			"username":   c.Username,
+			"message_id": markRead.MessageID,
+		},
+	})
+}
+
+func (c *Client) handleKickMember(req *request) {
+	var kick models.KickMember
+	if !c.decode(req, &kick) {
+		return
+	}
+
+	if _, _, err := c.authorizer.CanActOn(c.UserID, kick.UserID, kick.RoomID, authz.PermKickMember); err != nil {
+		c.sendFailure(req, kick.RoomID, err, "check permissions")
+		return
+	}
+
+	if err := c.roomRepo.RemoveMember(kick.RoomID, kick.UserID); err != nil {
+		c.sendFailure(req, kick.RoomID, err, "remove member")
+		return
+	}
+
+	c.hub.KickFromRoom(kick.RoomID, kick.UserID, c.UserID)
+}
+
+// sendMessageAck tells the sender their message was stored, echoing the
+// client_msg_id so the UI can mark its pending copy as sent.
+func (c *Client) sendMessageAck(req *request, chatMessage *models.ChatMessage, message *models.Message) {
+	c.sendFrame(req, "message_ack", models.MessageAck{
+		ClientMsgID: chatMessage.ClientMsgID,
+		MessageID:   message.ID,
+		RoomID:      message.RoomID,
+		Seq:         message.Seq,
+		CreatedAt:   message.CreatedAt,
+	})
+}
+
+// sendMessageError tells the sender their message was not stored.
+func (c *Client) sendMessageError(req *request, chatMessage *models.ChatMessage, code, reason string) {
+	c.sendFrame(req, "message_error", models.MessageError{
+		ClientMsgID: chatMessage.ClientMsgID,
+		RoomID:      chatMessage.RoomID,
+		Code:        code,
+		Error:       reason,
+	})
+}
@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"encoding/json"
+	"real-time-chat/internal/models"
+	"reflect"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// wireCodec is a wire format for frames. Both formats carry the same
+// documents: MessagePack maps use the JSON field names, so one schema
+// describes both.
+type wireCodec struct {
+	// index identifies the codec in a frame's encoding cache.
+	index       int
+	messageType int
+	encode      func(msg models.WSMessage) ([]byte, error)
+	decode      func(data []byte, req *request) error
+}
+
+var jsonCodec = &wireCodec{
+	index:       0,
+	messageType: websocket.TextMessage,
+	encode: func(msg models.WSMessage) ([]byte, error) {
+		return json.Marshal(msg)
+	},
+	decode: func(data []byte, req *request) error {
+		return json.Unmarshal(data, req)
+	},
+}
+
+var msgpackHandle = newMsgpackHandle()
+
+// msgpackCodec sends binary frames. Timestamps use the MessagePack
+// timestamp extension instead of RFC 3339 strings.
+var msgpackCodec = &wireCodec{
+	index:       1,
+	messageType: websocket.BinaryMessage,
+	encode: func(msg models.WSMessage) ([]byte, error) {
+		var data []byte
+		err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(msg)
+		return data, err
+	},
+	decode: func(data []byte, req *request) error {
+		var frame struct {
+			ID      string      `codec:"id"`
+			Type    string      `codec:"type"`
+			Payload interface{} `codec:"payload"`
+		}
+		if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&frame); err != nil {
+			return err
+		}
+
+		// Handlers decode payloads from JSON; converting here keeps them
+		// unaware of the wire format
+		req.ID = frame.ID
+		req.Type = frame.Type
+		if frame.Payload == nil {
+			return nil
+		}
+		payload, err := json.Marshal(frame.Payload)
+		if err != nil {
+			return err
+		}
+		req.Payload = payload
+		return nil
+	},
+}
+
+// codecCount sizes the per-frame encoding cache.
+const codecCount = 2
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.RawToString = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}
@@ -0,0 +1,168 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"real-time-chat/internal/models"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+func decodeMsgpack(t *testing.T, data []byte) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&doc); err != nil {
+		t.Fatalf("decoding MessagePack: %v", err)
+	}
+	return doc
+}
+
+func TestMsgpackEncodesJSONFields(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	parent := 7
+	f := newFrame(models.WSMessage{
+		ID:   "r1",
+		Type: "thread_reply",
+		Payload: &models.Message{
+			ID:        42,
+			RoomID:    3,
+			Seq:       9,
+			Content:   "hi",
+			CreatedAt: created,
+			ParentID:  &parent,
+		},
+	})
+
+	data, err := f.encode(msgpackCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := decodeMsgpack(t, data)
+	if doc["id"] != "r1" || doc["type"] != "thread_reply" {
+		t.Fatalf("envelope = %v", doc)
+	}
+
+	payload, ok := doc["payload"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("payload = %T, want a map", doc["payload"])
+	}
+	tests := []struct {
+		field string
+		want  interface{}
+	}{
+		{"id", int64(42)},
+		{"room_id", int64(3)},
+		{"seq", int64(9)},
+		{"content", "hi"},
+		{"parent_id", int64(7)},
+		{"is_deleted", false},
+	}
+	for _, tt := range tests {
+		if got := payload[tt.field]; got != tt.want {
+			t.Errorf("payload[%q] = %#v, want %#v", tt.field, got, tt.want)
+		}
+	}
+
+	// Timestamps use the MessagePack timestamp extension, not strings
+	if got, ok := payload["created_at"].(time.Time); !ok || !got.Equal(created) {
+		t.Errorf("created_at = %#v, want %v", payload["created_at"], created)
+	}
+	for _, field := range []string{"edited_at", "deleted_at", "client_msg_id"} {
+		if _, ok := payload[field]; ok {
+			t.Errorf("payload has %q, want it omitted", field)
+		}
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		frame       map[string]interface{}
+		wantID      string
+		wantType    string
+		wantPayload string
+	}{
+		{
+			name:        "with payload",
+			frame:       map[string]interface{}{"id": "a", "type": "send_message", "payload": map[string]interface{}{"room_id": 3, "content": "hi"}},
+			wantID:      "a",
+			wantType:    "send_message",
+			wantPayload: `{"content":"hi","room_id":3}`,
+		},
+		{
+			name:     "without payload",
+			frame:    map[string]interface{}{"type": "ping"},
+			wantType: "ping",
+		},
+	}
+
+	for _, tt := range tests {
+		jsonData, err := json.Marshal(tt.frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msgpackData []byte
+		if err := codec.NewEncoderBytes(&msgpackData, msgpackHandle).Encode(tt.frame); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, wc := range []*wireCodec{jsonCodec, msgpackCodec} {
+			data := jsonData
+			if wc == msgpackCodec {
+				data = msgpackData
+			}
+
+			var req request
+			if err := wc.decode(data, &req); err != nil {
+				t.Errorf("%s (codec %d): %v", tt.name, wc.index, err)
+				continue
+			}
+			if req.ID != tt.wantID || req.Type != tt.wantType {
+				t.Errorf("%s (codec %d): got id %q type %q", tt.name, wc.index, req.ID, req.Type)
+			}
+			if tt.wantPayload == "" {
+				continue
+			}
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, req.Payload); err != nil || compact.String() != tt.wantPayload {
+				t.Errorf("%s (codec %d): payload = %s, want %s", tt.name, wc.index, req.Payload, tt.wantPayload)
+			}
+		}
+	}
+}
+
+// A frame relayed from another instance is sent exactly as that instance
+// would have sent it to its own clients.
+func TestRelayedFrameKeepsEncodings(t *testing.T) {
+	local := newFrame(models.WSMessage{
+		Type:    "new_message",
+		Payload: &models.Message{ID: 1, CreatedAt: time.Unix(1700000000, 0).UTC()},
+	})
+
+	var encoded [codecCount][]byte
+	for _, wc := range []*wireCodec{jsonCodec, msgpackCodec} {
+		data, err := local.encode(wc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded[wc.index] = data
+	}
+
+	relayed := newRelayedFrame("new_message", encoded)
+	for _, wc := range []*wireCodec{jsonCodec, msgpackCodec} {
+		data, err := relayed.encode(wc)
+		if err != nil {
+			t.Fatalf("codec %d: %v", wc.index, err)
+		}
+		if !bytes.Equal(data, encoded[wc.index]) {
+			t.Errorf("codec %d: relayed frame differs from local encoding", wc.index)
+		}
+	}
+
+	partial := newRelayedFrame("new_message", [codecCount][]byte{encoded[jsonCodec.index], nil})
+	if _, err := partial.encode(msgpackCodec); err != errNotRelayed {
+		t.Errorf("missing encoding: got %v, want errNotRelayed", err)
+	}
+}
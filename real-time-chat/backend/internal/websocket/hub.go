@@ -1,237 +1,397 @@
 package websocket
 
 import (
-	"encoding/json"
 	"log"
+	"real-time-chat/internal/backplane"
 	"real-time-chat/internal/models"
 	"real-time-chat/internal/repository"
-	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
 )
 
+// Hub tracks connected clients and the rooms they follow. Rooms and users
+// are split across shards with their own locks, room broadcasts are
+// delivered by one worker per shard, and presence bookkeeping runs on a
+// worker of its own, so no single goroutine or lock serialises the server.
 type Hub struct {
-	clients    map[*Client]bool
-	rooms      map[int]map[*Client]bool
-	broadcast  chan *BroadcastMessage
-	register   chan *Client
-	unregister chan *Client
-	mutex      sync.RWMutex
-	messageRepo *repository.MessageRepository
-	userRepo    *repository.UserRepository
+	rooms [hubShards]*roomShard
+	users [hubShards]*userShard
+
+	// presence queues connects and disconnects for the presence worker,
+	// spilling into presenceBacklog when it is full
+	presence        chan presenceChange
+	presenceBacklog presenceBacklog
+
+	// online caches who is visible as online for presence snapshots; it is
+	// kept by the presence worker
+	online presenceCache
+
+	// legacyClients counts the version 1 clients here, the only ones still
+	// sent the online_users list
+	legacyClients atomic.Int64
+
+	policy SlowConsumerPolicy
+
+	messageRepo    *repository.MessageRepository
+	userRepo       *repository.UserRepository
+	connectionRepo *repository.ConnectionRepository
+
+	// backplane relays events to other server instances; nil when this is
+	// the only one
+	backplane backplane.Backplane
+	nodeID    string
+	outbound  chan *backplane.Event
 }
 
 type BroadcastMessage struct {
-	RoomID  int
-	Message []byte
+	RoomID int
+	frame  *frame
+
+	// exclude and the connections of excludeUserID are skipped
+	exclude       *Client
+	excludeUserID int
+
+	// close evicts every client from the room once the frame is delivered
+	close bool
 }
 
-func NewHub(messageRepo *repository.MessageRepository, userRepo *repository.UserRepository) *Hub {
-	return &Hub{
-		clients:     make(map[*Client]bool),
-		rooms:       make(map[int]map[*Client]bool),
-		broadcast:   make(chan *BroadcastMessage, 256),
-		register:    make(chan *Client),
-		unregister:  make(chan *Client),
-		messageRepo: messageRepo,
-		userRepo:    userRepo,
+// NewHub creates a hub. bp may be nil when a single instance serves every
+// client; unset policy fields take their defaults.
+func NewHub(messageRepo *repository.MessageRepository, userRepo *repository.UserRepository,
+	connectionRepo *repository.ConnectionRepository, bp backplane.Backplane, policy SlowConsumerPolicy) *Hub {
+	h := &Hub{
+		presence:       make(chan presenceChange, 1024),
+		policy:         policy.withDefaults(),
+		messageRepo:    messageRepo,
+		userRepo:       userRepo,
+		connectionRepo: connectionRepo,
+		backplane:      bp,
+		nodeID:         newID(),
+	}
+	for i := range h.rooms {
+		h.rooms[i] = newRoomShard()
+		h.users[i] = newUserShard()
 	}
+	if bp != nil {
+		h.outbound = make(chan *backplane.Event, 1024)
+	}
+	return h
 }
 
+// Run starts the shard workers and then runs the presence worker until the
+// process exits.
 func (h *Hub) Run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mutex.Lock()
-			h.clients[client] = true
-			h.userRepo.UpdateOnlineStatus(client.UserID, true)
-			h.mutex.Unlock()
-			log.Printf("Client connected: %s (ID: %d)", client.Username, client.UserID)
-			h.broadcastOnlineUsers()
-
-		case client := <-h.unregister:
-			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				// Remove from all rooms
-				for roomID := range h.rooms {
-					delete(h.rooms[roomID], client)
-				}
-				h.userRepo.UpdateOnlineStatus(client.UserID, false)
-			}
-			h.mutex.Unlock()
-			log.Printf("Client disconnected: %s (ID: %d)", client.Username, client.UserID)
-			h.broadcastOnlineUsers()
-
-		case message := <-h.broadcast:
-			h.mutex.RLock()
-			if clients, ok := h.rooms[message.RoomID]; ok {
-				for client := range clients {
-					select {
-					case client.send <- message.Message:
-					default:
-						close(client.send)
-						delete(h.clients, client)
-					}
-				}
-			}
-			h.mutex.RUnlock()
+	for _, shard := range h.rooms {
+		go shard.run()
+	}
+
+	if h.backplane != nil {
+		go h.publishLoop()
+		if err := h.backplane.Subscribe(h.applyRemote); err != nil {
+			log.Printf("Error subscribing to backplane: %v", err)
 		}
+	} else if err := h.connectionRepo.Reset(); err != nil {
+		// Alone, this instance owns every connection a previous run recorded
+		log.Printf("Error resetting connections: %v", err)
 	}
+
+	h.runPresence()
 }
 
-func (h *Hub) JoinRoom(client *Client, roomID int) {
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
+func (h *Hub) Register(client *Client) {
+	us := h.userShard(client.UserID)
+	us.mu.Lock()
+	if us.clients[client.UserID] == nil {
+		us.clients[client.UserID] = make(map[*Client]bool)
+	}
+	us.clients[client.UserID][client] = true
+	us.mu.Unlock()
+	if client.protocol == protocolV1 {
+		h.legacyClients.Add(1)
+	}
+
+	log.Printf("Client connected: %s (ID: %d, connection %s)", client.Username, client.UserID, client.ConnID)
+	h.queuePresence(presenceChange{client: client, userID: client.UserID, kind: presenceConnect})
+}
+
+// Unregister removes a client from the hub and stops its write pump. It is
+// safe to call more than once.
+func (h *Hub) Unregister(client *Client) {
+	us := h.userShard(client.UserID)
+	us.mu.Lock()
+	conns := us.clients[client.UserID]
+	if !conns[client] {
+		us.mu.Unlock()
+		return
+	}
+	delete(conns, client)
+	if len(conns) == 0 {
+		delete(us.clients, client.UserID)
+	}
+	us.mu.Unlock()
+	if client.protocol == protocolV1 {
+		h.legacyClients.Add(-1)
+	}
 
-	if h.rooms[roomID] == nil {
-		h.rooms[roomID] = make(map[*Client]bool)
+	for _, roomID := range client.leaveAllRooms() {
+		rs := h.roomShard(roomID)
+		rs.mu.Lock()
+		rs.remove(client, roomID)
+		rs.mu.Unlock()
+	}
+	client.close(websocket.CloseNormalClosure, "")
+
+	log.Printf("Client disconnected: %s (ID: %d, connection %s)", client.Username, client.UserID, client.ConnID)
+	h.queuePresence(presenceChange{client: client, userID: client.UserID, kind: presenceDisconnect})
+}
+
+func (h *Hub) JoinRoom(client *Client, roomID int) {
+	rs := h.roomShard(roomID)
+	rs.mu.Lock()
+	if !rs.add(client, roomID) {
+		rs.mu.Unlock()
+		return
 	}
-	h.rooms[roomID][client] = true
 	log.Printf("User %s joined room %d", client.Username, roomID)
 
 	// Notify room members
-	notification := models.WSMessage{
+	notification := newFrame(models.WSMessage{
 		Type: "user_joined",
 		Payload: map[string]interface{}{
 			"room_id":  roomID,
 			"user_id":  client.UserID,
 			"username": client.Username,
 		},
-	}
-	h.notifyRoom(roomID, notification, client)
+	})
+	rs.mu.Unlock()
+
+	rs.broadcast <- &BroadcastMessage{RoomID: roomID, frame: notification, exclude: client}
+	h.relay(&backplane.Event{Kind: backplane.KindRoom, RoomID: roomID}, notification)
 }
 
 func (h *Hub) LeaveRoom(client *Client, roomID int) {
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
-
-	if clients, ok := h.rooms[roomID]; ok {
-		delete(clients, client)
-		log.Printf("User %s left room %d", client.Username, roomID)
-
-		// Notify room members
-		notification := models.WSMessage{
-			Type: "user_left",
-			Payload: map[string]interface{}{
-				"room_id":  roomID,
-				"user_id":  client.UserID,
-				"username": client.Username,
-			},
-		}
-		h.notifyRoomLocked(roomID, notification, client)
+	rs := h.roomShard(roomID)
+	rs.mu.Lock()
+	if !rs.rooms[roomID][client] {
+		rs.mu.Unlock()
+		return
 	}
+	rs.remove(client, roomID)
+	log.Printf("User %s left room %d", client.Username, roomID)
+
+	// Notify room members
+	notification := newFrame(models.WSMessage{
+		Type: "user_left",
+		Payload: map[string]interface{}{
+			"room_id":  roomID,
+			"user_id":  client.UserID,
+			"username": client.Username,
+		},
+	})
+	rs.mu.Unlock()
+
+	rs.broadcast <- &BroadcastMessage{RoomID: roomID, frame: notification, exclude: client}
+	h.relay(&backplane.Event{Kind: backplane.KindRoom, RoomID: roomID}, notification)
 }
 
-func (h *Hub) BroadcastToRoom(roomID int, message *models.Message) {
-	wsMessage := models.WSMessage{
-		Type:    "new_message",
-		Payload: message,
-	}
+// Subscribe attaches a client to a room without announcing it, used for
+// conversations such as DMs that a client follows implicitly.
+func (h *Hub) Subscribe(client *Client, roomID int) {
+	rs := h.roomShard(roomID)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.add(client, roomID)
+}
+
+// SubscribeUser attaches every live connection of a user to a room.
+func (h *Hub) SubscribeUser(roomID, userID int) {
+	h.subscribeUser(roomID, userID)
+	h.relay(&backplane.Event{Kind: backplane.KindSubscribe, RoomID: roomID, UserID: userID}, nil)
+}
 
-	data, err := json.Marshal(wsMessage)
-	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
+func (h *Hub) subscribeUser(roomID, userID int) {
+	clients := h.userClients(userID)
+	if len(clients) == 0 {
 		return
 	}
 
-	h.broadcast <- &BroadcastMessage{
-		RoomID:  roomID,
-		Message: data,
+	rs := h.roomShard(roomID)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for _, client := range clients {
+		rs.add(client, roomID)
 	}
 }
 
+func (h *Hub) IsInRoom(client *Client, roomID int) bool {
+	rs := h.roomShard(roomID)
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	return rs.rooms[roomID][client]
+}
+
+func (h *Hub) BroadcastToRoom(roomID int, message *models.Message) {
+	h.BroadcastEvent(roomID, models.WSMessage{
+		Type:    "new_message",
+		Payload: message,
+	})
+}
+
 func (h *Hub) BroadcastTyping(roomID int, userID int, username string, isTyping bool) {
-	wsMessage := models.WSMessage{
+	f := newFrame(models.WSMessage{
 		Type: "typing",
 		Payload: models.TypingIndicator{
 			RoomID:   roomID,
 			Username: username,
 			IsTyping: isTyping,
 		},
-	}
+	})
 
-	data, err := json.Marshal(wsMessage)
-	if err != nil {
-		return
+	h.roomShard(roomID).broadcast <- &BroadcastMessage{RoomID: roomID, frame: f, excludeUserID: userID}
+	h.relay(&backplane.Event{Kind: backplane.KindRoom, RoomID: roomID, ExcludeUserID: userID}, f)
+}
+
+// BroadcastThreadReply announces a new reply to the room along with the
+// parent's updated counters, so clients can refresh an open thread pane and
+// the "N replies" badge without reloading the room.
+func (h *Hub) BroadcastThreadReply(reply *models.Message, parent *models.Message) {
+	h.BroadcastEvent(reply.RoomID, models.WSMessage{
+		Type:    "thread_reply",
+		Payload: reply,
+	})
+	h.BroadcastThreadUpdated(parent)
+}
+
+// BroadcastThreadUpdated announces a thread parent's current reply counters,
+// after a reply was posted or deleted.
+func (h *Hub) BroadcastThreadUpdated(parent *models.Message) {
+	h.BroadcastEvent(parent.RoomID, models.WSMessage{
+		Type: "thread_updated",
+		Payload: map[string]interface{}{
+			"message_id":    parent.ID,
+			"room_id":       parent.RoomID,
+			"reply_count":   parent.ReplyCount,
+			"last_reply_at": parent.LastReplyAt,
+		},
+	})
+}
+
+// BroadcastEvent fans an arbitrary event out to every client in the room.
+func (h *Hub) BroadcastEvent(roomID int, message models.WSMessage) {
+	f := newFrame(message)
+	h.roomShard(roomID).broadcast <- &BroadcastMessage{
+		RoomID: roomID,
+		frame:  f,
 	}
+	h.relay(&backplane.Event{Kind: backplane.KindRoom, RoomID: roomID}, f)
+}
 
-	h.mutex.RLock()
-	if clients, ok := h.rooms[roomID]; ok {
-		for client := range clients {
-			if client.UserID != userID {
-				select {
-				case client.send <- data:
-				default:
-				}
-			}
+// RemoveUserFromRoom detaches every connection of a user from a room, used
+// when they are kicked so they stop receiving its traffic immediately.
+func (h *Hub) RemoveUserFromRoom(roomID, userID int) {
+	h.removeUserFromRoom(roomID, userID)
+	h.relay(&backplane.Event{Kind: backplane.KindUnsubscribe, RoomID: roomID, UserID: userID}, nil)
+}
+
+func (h *Hub) removeUserFromRoom(roomID, userID int) {
+	rs := h.roomShard(roomID)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for client := range rs.rooms[roomID] {
+		if client.UserID == userID {
+			rs.remove(client, roomID)
 		}
 	}
-	h.mutex.RUnlock()
 }
 
-func (h *Hub) notifyRoom(roomID int, message models.WSMessage, exclude *Client) {
-	data, err := json.Marshal(message)
-	if err != nil {
-		return
-	}
+// CloseRoom tells everyone in a deleted room, once traffic already queued
+// for it is delivered, and then evicts them so no further traffic is routed
+// to it.
+func (h *Hub) CloseRoom(roomID int) {
+	notification := newFrame(models.WSMessage{
+		Type: "room_deleted",
+		Payload: map[string]interface{}{
+			"room_id": roomID,
+		},
+	})
 
-	if clients, ok := h.rooms[roomID]; ok {
-		for client := range clients {
-			if client != exclude {
-				select {
-				case client.send <- data:
-				default:
-				}
-			}
-		}
+	h.roomShard(roomID).broadcast <- &BroadcastMessage{RoomID: roomID, frame: notification, close: true}
+	h.relay(&backplane.Event{Kind: backplane.KindCloseRoom, RoomID: roomID}, notification)
+}
+
+// KickFromRoom removes a user's connections from the room, tells the room
+// who was removed and tells the kicked user why their feed stopped.
+func (h *Hub) KickFromRoom(roomID, userID, kickedBy int) {
+	h.RemoveUserFromRoom(roomID, userID)
+
+	event := models.WSMessage{
+		Type: "member_removed",
+		Payload: map[string]interface{}{
+			"room_id":   roomID,
+			"user_id":   userID,
+			"kicked_by": kickedBy,
+		},
 	}
+	h.BroadcastEvent(roomID, event)
+	h.SendToUser(userID, event)
 }
 
-func (h *Hub) notifyRoomLocked(roomID int, message models.WSMessage, exclude *Client) {
-	data, err := json.Marshal(message)
-	if err != nil {
-		return
+// ConnectedUsers filters userIDs down to those with at least one live
+// connection, including invisible users. With a backplane the users may be
+// connected to any instance, so the shared connection list is consulted
+// instead of local connections.
+func (h *Hub) ConnectedUsers(userIDs []int) []int {
+	if h.backplane != nil {
+		connected, err := h.connectionRepo.Connected(userIDs)
+		if err != nil {
+			log.Printf("Error getting connected users: %v", err)
+		}
+		return connected
 	}
 
-	if clients, ok := h.rooms[roomID]; ok {
-		for client := range clients {
-			if client != exclude {
-				select {
-				case client.send <- data:
-				default:
-				}
-			}
+	var connected []int
+	for _, id := range userIDs {
+		if len(h.userClients(id)) > 0 {
+			connected = append(connected, id)
 		}
 	}
+	return connected
 }
 
-func (h *Hub) Register(client *Client) {
-	h.register <- client
-}
+// SendToUser delivers an event to every connection of a user, regardless of
+// which rooms they have joined.
+func (h *Hub) SendToUser(userID int, message models.WSMessage) {
+	f := newFrame(message)
 
-func (h *Hub) broadcastOnlineUsers() {
-	users, err := h.userRepo.GetOnlineUsers()
-	if err != nil {
-		log.Printf("Error getting online users: %v", err)
-		return
-	}
+	h.deliverToUser(userID, f)
+	h.relay(&backplane.Event{Kind: backplane.KindUser, UserID: userID}, f)
+}
 
-	wsMessage := models.WSMessage{
-		Type:    "online_users",
-		Payload: users,
-	}
+// deliverToUser sends a frame to every connection of a user here.
+func (h *Hub) deliverToUser(userID int, f *frame) {
+	us := h.userShard(userID)
+	us.mu.RLock()
+	defer us.mu.RUnlock()
 
-	data, err := json.Marshal(wsMessage)
-	if err != nil {
-		return
+	for client := range us.clients[userID] {
+		client.trySend(f)
 	}
+}
 
-	h.mutex.RLock()
-	for client := range h.clients {
-		select {
-		case client.send <- data:
-		default:
+// deliverToAll sends a frame to every client here.
+func (h *Hub) deliverToAll(f *frame) {
+	for _, us := range h.users {
+		us.mu.RLock()
+		for _, conns := range us.clients {
+			for client := range conns {
+				client.trySend(f)
+			}
 		}
+		us.mu.RUnlock()
 	}
-	h.mutex.RUnlock()
 }
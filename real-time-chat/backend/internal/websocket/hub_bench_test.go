@@ -0,0 +1,119 @@
+package websocket
+
+import (
+	"io"
+	"log"
+	"os"
+	"real-time-chat/internal/models"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	benchClients = 20000
+	benchRooms   = 1000
+)
+
+// benchHub registers benchClients clients, one per user, spread evenly over
+// benchRooms rooms. Each client's frames are drained the way its write pump
+// would and counted in the returned counter. Connection logging is silenced
+// for the benchmark.
+func benchHub(b *testing.B) (*Hub, *atomic.Int64) {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	h := startTestHub(b, nil)
+
+	delivered := new(atomic.Int64)
+	clients := make([]*Client, benchClients)
+	for i := range clients {
+		c := newTestClient(h, i+1)
+		h.Register(c)
+		h.Subscribe(c, i%benchRooms+1)
+		go drainClient(c, delivered)
+		clients[i] = c
+	}
+	b.Cleanup(func() {
+		for _, c := range clients {
+			h.Unregister(c)
+		}
+	})
+	return h, delivered
+}
+
+func drainClient(c *Client, delivered *atomic.Int64) {
+	for {
+		select {
+		case <-c.send:
+			delivered.Add(1)
+			c.refill()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// waitForDelivery returns once the clients have read want frames, so a
+// benchmark covers the fan-out and not just the queueing.
+func waitForDelivery(b *testing.B, delivered *atomic.Int64, want int) {
+	deadline := time.Now().Add(time.Minute)
+	for delivered.Load() < int64(want) {
+		if time.Now().After(deadline) {
+			b.Fatalf("clients read %d of %d frames", delivered.Load(), want)
+		}
+		runtime.Gosched()
+	}
+}
+
+func BenchmarkBroadcastEvent(b *testing.B) {
+	h, delivered := benchHub(b)
+	message := &models.Message{ID: 1, Content: "hello"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.BroadcastEvent(i%benchRooms+1, models.WSMessage{Type: "new_message", Payload: message})
+	}
+	waitForDelivery(b, delivered, b.N*benchClients/benchRooms)
+}
+
+func BenchmarkBroadcastEventParallel(b *testing.B) {
+	h, delivered := benchHub(b)
+	message := &models.Message{ID: 1, Content: "hello"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			h.BroadcastEvent(i%benchRooms+1, models.WSMessage{Type: "new_message", Payload: message})
+		}
+	})
+	waitForDelivery(b, delivered, b.N*benchClients/benchRooms)
+}
+
+func BenchmarkRegisterUnregister(b *testing.B) {
+	h, _ := benchHub(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := newTestClient(h, benchClients+1+i)
+		h.Register(c)
+		h.Subscribe(c, i%benchRooms+1)
+		h.Unregister(c)
+	}
+}
+
+func BenchmarkSendToUser(b *testing.B) {
+	h, delivered := benchHub(b)
+	message := models.WSMessage{Type: "room_invite", Payload: map[string]interface{}{"room_id": 1}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.SendToUser(i%benchClients+1, message)
+	}
+	waitForDelivery(b, delivered, b.N)
+}
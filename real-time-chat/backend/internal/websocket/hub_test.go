@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"encoding/json"
+	"real-time-chat/internal/backplane"
+	"real-time-chat/internal/models"
+	"testing"
+)
+
+// Two hubs sharing a Memory backplane stand in for two server instances.
+func TestHubsShareRoomsOverBackplane(t *testing.T) {
+	bp := backplane.NewMemory()
+	defer bp.Close()
+	h1 := startTestHub(t, bp)
+	h2 := startTestHub(t, bp)
+
+	// User 1 is on the first instance; user 2 is connected to both
+	a := newTestClient(h1, 1)
+	b := newTestClient(h2, 2)
+	c := newTestClient(h1, 2)
+	for _, client := range []*Client{a, b, c} {
+		client.hub.Register(client)
+		client.hub.Subscribe(client, 5)
+	}
+	h1.Subscribe(a, 6)
+	h2.Subscribe(b, 6)
+
+	t.Run("room broadcast", func(t *testing.T) {
+		h1.BroadcastToRoom(5, &models.Message{ID: 1, RoomID: 5})
+		for _, client := range []*Client{a, b, c} {
+			expectFrame(t, client, "new_message")
+		}
+	})
+
+	t.Run("typing skips the typist everywhere", func(t *testing.T) {
+		h2.BroadcastTyping(5, 2, "user2", true)
+		expectFrame(t, a, "typing")
+		expectNoFrame(t, b)
+		expectNoFrame(t, c)
+	})
+
+	t.Run("send to user", func(t *testing.T) {
+		h1.SendToUser(2, models.WSMessage{Type: "room_invite"})
+		expectFrame(t, b, "room_invite")
+		expectFrame(t, c, "room_invite")
+		expectNoFrame(t, a)
+	})
+
+	t.Run("kick", func(t *testing.T) {
+		h1.KickFromRoom(5, 2, 1)
+		expectFrame(t, a, "member_removed")
+		expectFrame(t, b, "member_removed")
+		expectFrame(t, c, "member_removed")
+
+		h2.BroadcastToRoom(5, &models.Message{ID: 2, RoomID: 5})
+		expectFrame(t, a, "new_message")
+		expectNoFrame(t, b)
+		expectNoFrame(t, c)
+	})
+
+	t.Run("close room", func(t *testing.T) {
+		h2.CloseRoom(6)
+		expectFrame(t, a, "room_deleted")
+		expectFrame(t, b, "room_deleted")
+
+		h1.BroadcastToRoom(6, &models.Message{ID: 3, RoomID: 6})
+		expectNoFrame(t, a)
+		expectNoFrame(t, b)
+		if h1.IsInRoom(a, 6) || h2.IsInRoom(b, 6) {
+			t.Error("clients still subscribed to a closed room")
+		}
+	})
+}
+
+// Joins, leaves, typing and closing a room are queued behind the room's
+// messages rather than overtaking them.
+func TestRoomFramesKeepOrder(t *testing.T) {
+	h := NewHub(nil, nil, nil, nil, SlowConsumerPolicy{})
+	a := newTestClient(h, 1)
+	b := newTestClient(h, 2)
+	c := newTestClient(h, 3)
+	for _, client := range []*Client{a, b, c} {
+		h.Register(client)
+	}
+	h.Subscribe(a, 7)
+	h.Subscribe(b, 7)
+	go func() {
+		for range h.presence {
+		}
+	}()
+
+	// Nothing is delivered until the shard worker starts, and membership is
+	// read at delivery, so user 2 has left and user 3 joined before any of it
+	h.BroadcastToRoom(7, &models.Message{ID: 1, RoomID: 7})
+	h.JoinRoom(c, 7)
+	h.BroadcastTyping(7, 2, "user2", true)
+	h.LeaveRoom(b, 7)
+	h.BroadcastToRoom(7, &models.Message{ID: 2, RoomID: 7})
+	h.CloseRoom(7)
+	go h.roomShard(7).run()
+
+	tests := []struct {
+		client *Client
+		want   []string
+	}{
+		{a, []string{"new_message", "user_joined", "typing", "user_left", "new_message", "room_deleted"}},
+		{b, nil},
+		{c, []string{"new_message", "typing", "user_left", "new_message", "room_deleted"}},
+	}
+	for _, tt := range tests {
+		for _, msgType := range tt.want {
+			expectFrame(t, tt.client, msgType)
+		}
+		expectNoFrame(t, tt.client)
+	}
+}
+
+func TestBroadcastThreadUpdated(t *testing.T) {
+	h := startTestHub(t, nil)
+	a := newTestClient(h, 1)
+	h.Register(a)
+	h.Subscribe(a, 3)
+
+	tests := []struct {
+		name   string
+		parent *models.Message
+	}{
+		{"reply posted", &models.Message{ID: 5, RoomID: 3, ReplyCount: 2}},
+		{"last reply deleted", &models.Message{ID: 5, RoomID: 3, ReplyCount: 0}},
+	}
+	for _, tt := range tests {
+		h.BroadcastThreadUpdated(tt.parent)
+
+		var payload struct {
+			MessageID  int `json:"message_id"`
+			ReplyCount int `json:"reply_count"`
+		}
+		f := expectFrame(t, a, "thread_updated")
+		if err := json.Unmarshal(f.Payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.MessageID != tt.parent.ID || payload.ReplyCount != tt.parent.ReplyCount {
+			t.Errorf("%s: got %+v, want message %d with %d replies", tt.name, payload, tt.parent.ID, tt.parent.ReplyCount)
+		}
+	}
+}
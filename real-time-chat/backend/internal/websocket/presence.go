@@ -0,0 +1,415 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"real-time-chat/internal/backplane"
+	"real-time-chat/internal/models"
+	"real-time-chat/internal/repository"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// presenceFlushInterval is how often pending presence changes are
+	// written and announced. Connects and disconnects in between are
+	// coalesced and written in one transaction of a few set-based
+	// statements, so a reconnect storm costs the same handful of queries
+	// per interval however many clients it involves.
+	presenceFlushInterval = 500 * time.Millisecond
+
+	// connectionHeartbeat is how often this instance marks its connections
+	// as alive. Connections not marked for connectionStaleAfter belong to
+	// an instance that died without cleaning up and are dropped.
+	connectionHeartbeat  = time.Minute
+	connectionStaleAfter = 3 * connectionHeartbeat
+
+	// idleAfter is how long a connection may go without client activity
+	// before it counts as idle. A user whose connections are all idle shows
+	// as away. Idle connections and expired custom statuses are looked for
+	// every idleCheckInterval.
+	idleAfter         = 5 * time.Minute
+	idleCheckInterval = 30 * time.Second
+)
+
+type presenceKind int
+
+const (
+	presenceConnect presenceKind = iota
+	presenceDisconnect
+	presenceIdleChanged
+
+	// presenceStatus means a user changed their status; it has no client
+	presenceStatus
+
+	// presenceRemote carries a presence another instance announced
+	presenceRemote
+)
+
+type presenceChange struct {
+	client   *Client
+	userID   int
+	kind     presenceKind
+	presence *models.Presence
+}
+
+// presenceBacklog holds changes queued while the presence channel was full,
+// so connection handling never waits on the presence worker.
+type presenceBacklog struct {
+	mu      sync.Mutex
+	changes []presenceChange
+}
+
+// queuePresence hands a change to the presence worker without blocking.
+// Once the channel fills, changes go to the backlog until the worker has
+// caught up, so they are still seen in the order they were made.
+func (h *Hub) queuePresence(change presenceChange) {
+	b := &h.presenceBacklog
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.changes) == 0 {
+		select {
+		case h.presence <- change:
+			return
+		default:
+		}
+	}
+	b.changes = append(b.changes, change)
+}
+
+// drainPresence passes every queued change to receive, those in the channel
+// before those in the backlog. While the backlog is not empty nothing new
+// enters the channel, so this keeps the order they were queued in.
+func (h *Hub) drainPresence(receive func(presenceChange)) {
+drain:
+	for {
+		select {
+		case change := <-h.presence:
+			receive(change)
+		default:
+			break drain
+		}
+	}
+
+	b := &h.presenceBacklog
+	b.mu.Lock()
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+
+	for _, change := range changes {
+		receive(change)
+	}
+}
+
+// PresenceChanged announces a user's presence again after they changed
+// their status.
+func (h *Hub) PresenceChanged(userID int) {
+	h.queuePresence(presenceChange{userID: userID, kind: presenceStatus})
+}
+
+// remotePresence passes a presence frame relayed from another instance to
+// the presence worker, which keeps the online cache current with it.
+func (h *Hub) remotePresence(data []byte) {
+	var frame struct {
+		Payload models.Presence `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		log.Printf("Error reading relayed presence: %v", err)
+		return
+	}
+	h.queuePresence(presenceChange{userID: frame.Payload.UserID, kind: presenceRemote, presence: &frame.Payload})
+}
+
+// runPresence applies connection changes off the connection path. A user
+// stays online while any of their connections, on any instance, remains,
+// and only goes away once all of them are idle.
+func (h *Hub) runPresence() {
+	ticker := time.NewTicker(presenceFlushInterval)
+	defer ticker.Stop()
+	heartbeat := time.NewTicker(connectionHeartbeat)
+	defer heartbeat.Stop()
+	idleCheck := time.NewTicker(idleCheckInterval)
+	defer idleCheck.Stop()
+
+	pending := make(map[*Client]presenceKind)
+	changed := make(map[int]bool)
+	announced := make(map[int]string)
+
+	// listChanged is set when the online list version 1 clients get may
+	// be out of date
+	listChanged := false
+
+	receive := func(change presenceChange) {
+		switch {
+		case change.kind == presenceRemote:
+			h.online.set(change.presence)
+			listChanged = true
+		case change.client == nil:
+			changed[change.userID] = true
+		default:
+			queueConnectionChange(pending, change.client, change.kind)
+		}
+	}
+
+	h.refreshOnline()
+
+	for {
+		select {
+		case change := <-h.presence:
+			receive(change)
+
+		case <-ticker.C:
+			h.drainPresence(receive)
+			if err := h.connectionRepo.Apply(connectionChanges(pending), h.nodeID); err != nil {
+				log.Printf("Error updating connections: %v", err)
+			}
+			for client := range pending {
+				changed[client.UserID] = true
+			}
+			pending = make(map[*Client]presenceKind)
+
+			if len(changed) > 0 {
+				h.announcePresence(changed, announced)
+				changed = make(map[int]bool)
+				listChanged = true
+			}
+			if listChanged {
+				h.broadcastOnlineUsers()
+				listChanged = false
+			}
+
+		case <-heartbeat.C:
+			if err := h.connectionRepo.Touch(h.nodeID); err != nil {
+				log.Printf("Error touching connections: %v", err)
+			}
+			userIDs, err := h.connectionRepo.PruneStale(connectionStaleAfter)
+			if err != nil {
+				log.Printf("Error pruning stale connections: %v", err)
+			}
+			for _, id := range userIDs {
+				changed[id] = true
+			}
+			h.refreshOnline()
+
+		case <-idleCheck.C:
+			for _, client := range h.idleClients() {
+				queueConnectionChange(pending, client, presenceIdleChanged)
+			}
+			userIDs, err := h.userRepo.ClearExpiredStatuses()
+			if err != nil {
+				log.Printf("Error clearing expired statuses: %v", err)
+			}
+			for _, id := range userIDs {
+				changed[id] = true
+			}
+		}
+	}
+}
+
+// queueConnectionChange coalesces a change with any already pending for the
+// connection. A connection that comes and goes within one interval is never
+// written at all, and idle changes are folded into a pending connect, which
+// records the idle state current when it is written.
+func queueConnectionChange(pending map[*Client]presenceKind, client *Client, kind presenceKind) {
+	prev, ok := pending[client]
+	switch {
+	case !ok:
+		pending[client] = kind
+	case kind == presenceDisconnect && prev == presenceConnect:
+		delete(pending, client)
+	case kind == presenceIdleChanged:
+		// Already covered by the pending connect, disconnect or idle change
+	default:
+		pending[client] = kind
+	}
+}
+
+// connectionChanges sorts pending connection changes into the batch written
+// at the end of an interval, reading each connection's idle state as it is
+// now.
+func connectionChanges(pending map[*Client]presenceKind) repository.ConnectionChanges {
+	var changes repository.ConnectionChanges
+	for client, kind := range pending {
+		conn := &models.Connection{
+			ID:     client.ConnID,
+			UserID: client.UserID,
+			Device: client.Device,
+			Idle:   client.idle.Load(),
+		}
+		switch kind {
+		case presenceConnect:
+			changes.Added = append(changes.Added, conn)
+		case presenceDisconnect:
+			changes.Removed = append(changes.Removed, conn)
+		case presenceIdleChanged:
+			changes.Idle = append(changes.Idle, conn)
+		}
+	}
+	return changes
+}
+
+// idleClients marks connections with no recent activity as idle and returns
+// them. It runs on the presence worker, which records the change directly.
+func (h *Hub) idleClients() []*Client {
+	cutoff := time.Now().Add(-idleAfter).UnixNano()
+
+	var idle []*Client
+	for _, us := range h.users {
+		us.mu.RLock()
+		for _, conns := range us.clients {
+			for client := range conns {
+				if client.lastActive.Load() < cutoff && client.idle.CompareAndSwap(false, true) {
+					idle = append(idle, client)
+				}
+			}
+		}
+		us.mu.RUnlock()
+	}
+	return idle
+}
+
+// announcePresence tells everyone about users whose presence may have
+// changed: each client gets a presence frame for every user whose presence
+// differs from what this instance last announced. Users who went offline
+// are forgotten, so announced only holds users who are online.
+func (h *Hub) announcePresence(userIDs map[int]bool, announced map[int]string) {
+	ids := make([]int, 0, len(userIDs))
+	for id := range userIDs {
+		ids = append(ids, id)
+	}
+	users, err := h.userRepo.GetPresences(ids)
+	if err != nil {
+		log.Printf("Error getting presence of %d users: %v", len(ids), err)
+		return
+	}
+
+	for _, user := range users {
+		presence := presenceOf(user)
+		h.online.set(presence)
+
+		data, err := json.Marshal(presence)
+		if err != nil {
+			continue
+		}
+		if announced[user.ID] == string(data) {
+			continue
+		}
+		if presence.Status == models.PresenceOffline {
+			delete(announced, user.ID)
+		} else {
+			announced[user.ID] = string(data)
+		}
+
+		f := newFrame(models.WSMessage{
+			Type:    "presence",
+			Payload: presence,
+		})
+		h.deliverToAll(f)
+		h.relay(&backplane.Event{Kind: backplane.KindAll}, f)
+	}
+}
+
+// broadcastOnlineUsers sends the whole online list to the version 1 clients
+// here, which predate presence frames. Every instance sends it to its own
+// clients, and only when it has any.
+func (h *Hub) broadcastOnlineUsers() {
+	if h.legacyClients.Load() == 0 {
+		return
+	}
+
+	users, err := h.userRepo.GetOnlineUsers()
+	if err != nil {
+		log.Printf("Error getting online users: %v", err)
+		return
+	}
+
+	h.deliverToAll(newFrame(models.WSMessage{
+		Type:    "online_users",
+		Payload: users,
+	}))
+}
+
+// refreshOnline reloads the online cache, picking up anything the presence
+// frames seen since missed.
+func (h *Hub) refreshOnline() {
+	users, err := h.userRepo.GetOnlineUsers()
+	if err != nil {
+		log.Printf("Error getting online users: %v", err)
+		return
+	}
+
+	presences := make([]*models.Presence, 0, len(users))
+	for _, user := range users {
+		presences = append(presences, presenceOf(user))
+	}
+	h.online.replace(presences)
+}
+
+// presenceSnapshot lists everyone currently visible as online, sent to
+// version 2 clients when they connect so later presence frames have
+// something to apply to. It is served from the presence worker's cache.
+func (h *Hub) presenceSnapshot() []*models.Presence {
+	return h.online.snapshot()
+}
+
+func presenceOf(user *models.User) *models.Presence {
+	return &models.Presence{
+		UserID:          user.ID,
+		Username:        user.Username,
+		Status:          user.Status,
+		StatusText:      user.StatusText,
+		StatusEmoji:     user.StatusEmoji,
+		StatusExpiresAt: user.StatusExpiresAt,
+		LastSeenAt:      user.LastSeenAt,
+	}
+}
+
+// presenceCache holds the presence of users visible as online.
+type presenceCache struct {
+	mu    sync.RWMutex
+	users map[int]*models.Presence
+}
+
+// set records a user's presence, dropping them once they are offline.
+func (pc *presenceCache) set(presence *models.Presence) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if presence.Status == models.PresenceOffline {
+		delete(pc.users, presence.UserID)
+		return
+	}
+	if pc.users == nil {
+		pc.users = make(map[int]*models.Presence)
+	}
+	pc.users[presence.UserID] = presence
+}
+
+func (pc *presenceCache) replace(presences []*models.Presence) {
+	users := make(map[int]*models.Presence, len(presences))
+	for _, presence := range presences {
+		users[presence.UserID] = presence
+	}
+
+	pc.mu.Lock()
+	pc.users = users
+	pc.mu.Unlock()
+}
+
+// snapshot lists the cached users in ID order.
+func (pc *presenceCache) snapshot() []*models.Presence {
+	pc.mu.RLock()
+	snapshot := make([]*models.Presence, 0, len(pc.users))
+	for _, presence := range pc.users {
+		snapshot = append(snapshot, presence)
+	}
+	pc.mu.RUnlock()
+
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].UserID < snapshot[j].UserID
+	})
+	return snapshot
+}
@@ -0,0 +1,253 @@
+package websocket
+
+import (
+	"encoding/json"
+	"real-time-chat/internal/backplane"
+	"real-time-chat/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestQueueConnectionChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []presenceKind
+		want    presenceKind
+		pending bool
+	}{
+		{"connect", []presenceKind{presenceConnect}, presenceConnect, true},
+		{"disconnect", []presenceKind{presenceDisconnect}, presenceDisconnect, true},
+		{"idle change", []presenceKind{presenceIdleChanged}, presenceIdleChanged, true},
+		{"connect then disconnect is never written", []presenceKind{presenceConnect, presenceDisconnect}, 0, false},
+		{"idle folded into connect", []presenceKind{presenceConnect, presenceIdleChanged}, presenceConnect, true},
+		{"idle after disconnect ignored", []presenceKind{presenceDisconnect, presenceIdleChanged}, presenceDisconnect, true},
+		{"repeated idle changes", []presenceKind{presenceIdleChanged, presenceIdleChanged}, presenceIdleChanged, true},
+		{"disconnect after idle change", []presenceKind{presenceIdleChanged, presenceDisconnect}, presenceDisconnect, true},
+		{"connect, idle, disconnect", []presenceKind{presenceConnect, presenceIdleChanged, presenceDisconnect}, 0, false},
+	}
+
+	for _, tt := range tests {
+		pending := make(map[*Client]presenceKind)
+		client := &Client{}
+		for _, kind := range tt.changes {
+			queueConnectionChange(pending, client, kind)
+		}
+
+		got, ok := pending[client]
+		if ok != tt.pending || (ok && got != tt.want) {
+			t.Errorf("%s: pending = %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.pending)
+		}
+	}
+}
+
+// Changes to different connections of the same user are kept apart.
+func TestQueueConnectionChangePerConnection(t *testing.T) {
+	pending := make(map[*Client]presenceKind)
+	phone := &Client{UserID: 1}
+	laptop := &Client{UserID: 1}
+
+	queueConnectionChange(pending, phone, presenceConnect)
+	queueConnectionChange(pending, laptop, presenceDisconnect)
+	queueConnectionChange(pending, phone, presenceDisconnect)
+
+	if _, ok := pending[phone]; ok {
+		t.Error("short-lived phone connection still pending")
+	}
+	if pending[laptop] != presenceDisconnect {
+		t.Errorf("laptop pending %v, want disconnect", pending[laptop])
+	}
+}
+
+func TestPresenceCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates []*models.Presence
+		want    []int
+	}{
+		{"empty", nil, []int{}},
+		{"online users in ID order", []*models.Presence{
+			{UserID: 3, Status: models.PresenceOnline},
+			{UserID: 1, Status: models.PresenceAway},
+			{UserID: 2, Status: models.PresenceDND},
+		}, []int{1, 2, 3}},
+		{"offline users dropped", []*models.Presence{
+			{UserID: 1, Status: models.PresenceOnline},
+			{UserID: 2, Status: models.PresenceOnline},
+			{UserID: 1, Status: models.PresenceOffline},
+		}, []int{2}},
+		{"offline user never cached", []*models.Presence{
+			{UserID: 1, Status: models.PresenceOffline},
+		}, []int{}},
+	}
+
+	for _, tt := range tests {
+		var pc presenceCache
+		for _, presence := range tt.updates {
+			pc.set(presence)
+		}
+
+		got := []int{}
+		for _, presence := range pc.snapshot() {
+			got = append(got, presence.UserID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: snapshot has users %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPresenceCacheReplace(t *testing.T) {
+	var pc presenceCache
+	pc.set(&models.Presence{UserID: 1, Status: models.PresenceOnline})
+	pc.replace([]*models.Presence{{UserID: 2, Status: models.PresenceAway}})
+
+	snapshot := pc.snapshot()
+	if len(snapshot) != 1 || snapshot[0].UserID != 2 {
+		t.Errorf("snapshot after replace = %+v, want only user 2", snapshot)
+	}
+}
+
+// Connecting clients are sent the cached snapshot without a query; the hub
+// here has no repositories at all.
+func TestWelcomeSendsCachedSnapshot(t *testing.T) {
+	h := NewHub(nil, nil, nil, nil, SlowConsumerPolicy{})
+	h.online.set(&models.Presence{UserID: 7, Username: "ada", Status: models.PresenceDND, StatusText: "focusing"})
+	c := newTestClient(h, 1)
+
+	c.Welcome()
+	expectFrame(t, c, "welcome")
+	f := expectFrame(t, c, "presence_snapshot")
+
+	var snapshot []models.Presence
+	if err := json.Unmarshal(f.Payload, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	want := []models.Presence{{UserID: 7, Username: "ada", Status: models.PresenceDND, StatusText: "focusing"}}
+	if !reflect.DeepEqual(snapshot, want) {
+		t.Errorf("snapshot = %+v, want %+v", snapshot, want)
+	}
+}
+
+func TestLegacyClientCount(t *testing.T) {
+	h := startTestHub(t, nil)
+	v1 := newTestClient(h, 1)
+	v1.protocol = protocolV1
+	v2 := newTestClient(h, 2)
+
+	steps := []struct {
+		name string
+		do   func()
+		want int64
+	}{
+		{"v2 connects", func() { h.Register(v2) }, 0},
+		{"v1 connects", func() { h.Register(v1) }, 1},
+		{"v1 disconnects", func() { h.Unregister(v1) }, 0},
+		{"v1 disconnects again", func() { h.Unregister(v1) }, 0},
+		{"v2 disconnects", func() { h.Unregister(v2) }, 0},
+	}
+	for _, step := range steps {
+		step.do()
+		if got := h.legacyClients.Load(); got != step.want {
+			t.Errorf("after %s: %d legacy clients, want %d", step.name, got, step.want)
+		}
+	}
+
+	// Without version 1 clients the online list is not even queried
+	h.broadcastOnlineUsers()
+}
+
+func TestRelayedPresenceReachesWorker(t *testing.T) {
+	h := NewHub(nil, nil, nil, nil, SlowConsumerPolicy{})
+	data, err := newFrame(models.WSMessage{
+		Type:    "presence",
+		Payload: &models.Presence{UserID: 4, Username: "grace", Status: models.PresenceAway},
+	}).encode(jsonCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.applyRemote(&backplane.Event{Origin: "other", Kind: backplane.KindAll, Type: "presence", Frame: data})
+
+	select {
+	case change := <-h.presence:
+		if change.kind != presenceRemote || change.presence == nil ||
+			change.presence.UserID != 4 || change.presence.Status != models.PresenceAway {
+			t.Errorf("queued %+v, want the relayed presence of user 4", change)
+		}
+	default:
+		t.Fatal("relayed presence not queued for the presence worker")
+	}
+}
+
+func TestQueuePresenceKeepsOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		// queued changes go in, read of them are taken off the channel as
+		// the worker would, then more go in before the rest are drained
+		queued, read, more int
+	}{
+		{"fits the channel", 2, 0, 0},
+		{"spills into the backlog", 5, 0, 0},
+		{"backlog kept behind a freed channel", 5, 1, 2},
+		{"channel used again once drained", 3, 3, 2},
+	}
+
+	for _, tt := range tests {
+		h := NewHub(nil, nil, nil, nil, SlowConsumerPolicy{})
+		h.presence = make(chan presenceChange, 3)
+
+		next := 1
+		queue := func(n int) {
+			for i := 0; i < n; i++ {
+				h.queuePresence(presenceChange{userID: next})
+				next++
+			}
+		}
+
+		var got []int
+		queue(tt.queued)
+		for i := 0; i < tt.read; i++ {
+			got = append(got, (<-h.presence).userID)
+		}
+		queue(tt.more)
+		h.drainPresence(func(change presenceChange) {
+			got = append(got, change.userID)
+		})
+
+		want := make([]int, 0, next-1)
+		for id := 1; id < next; id++ {
+			want = append(want, id)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: received %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestConnectionChanges(t *testing.T) {
+	joined := &Client{ConnID: "a", UserID: 1, Device: "phone"}
+	left := &Client{ConnID: "b", UserID: 1}
+	idle := &Client{ConnID: "c", UserID: 2}
+	idle.idle.Store(true)
+
+	changes := connectionChanges(map[*Client]presenceKind{
+		joined: presenceConnect,
+		left:   presenceDisconnect,
+		idle:   presenceIdleChanged,
+	})
+
+	tests := []struct {
+		name  string
+		conns []*models.Connection
+		want  models.Connection
+	}{
+		{"added", changes.Added, models.Connection{ID: "a", UserID: 1, Device: "phone"}},
+		{"removed", changes.Removed, models.Connection{ID: "b", UserID: 1}},
+		{"idle", changes.Idle, models.Connection{ID: "c", UserID: 2, Idle: true}},
+	}
+	for _, tt := range tests {
+		if len(tt.conns) != 1 || *tt.conns[0] != tt.want {
+			t.Errorf("%s = %+v, want only %+v", tt.name, tt.conns, tt.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"real-time-chat/internal/authz"
+	"real-time-chat/internal/models"
+	"real-time-chat/internal/repository"
+	"sync"
+)
+
+// Error codes carried in error and message_error frames. They are part of
+// the protocol: clients branch on them, so existing codes must not change.
+const (
+	codeBadRequest  = "bad_request"
+	codeUnknownType = "unknown_type"
+	codeNotFound    = "not_found"
+	codeNotMember   = "not_member"
+	codeInviteOnly  = "invite_only"
+	codeForbidden   = "forbidden"
+	codeGone        = "gone"
+	codeInternal    = "internal_error"
+)
+
+// request is a frame received from the client. The payload is decoded by
+// the handler for its type; the optional id is echoed on the reply.
+type request struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// decode unmarshals the request payload into v, answering with a
+// bad_request error if it does not fit.
+func (c *Client) decode(req *request, v interface{}) bool {
+	if len(req.Payload) == 0 {
+		c.sendError(req, 0, codeBadRequest, "payload is required")
+		return false
+	}
+	if err := json.Unmarshal(req.Payload, v); err != nil {
+		c.sendError(req, 0, codeBadRequest, "invalid payload for "+req.Type)
+		return false
+	}
+	return true
+}
+
+// classifyError maps a handler error to a protocol code and client-facing
+// message. Errors the client cannot act on are logged and reported as a
+// failure to perform action.
+func classifyError(err error, action string) (string, string) {
+	switch {
+	case errors.Is(err, authz.ErrRoomNotFound), errors.Is(err, repository.ErrMessageNotFound):
+		return codeNotFound, err.Error()
+	case errors.Is(err, authz.ErrNotMember):
+		return codeNotMember, err.Error()
+	case errors.Is(err, authz.ErrInviteOnly):
+		return codeInviteOnly, err.Error()
+	case errors.Is(err, authz.ErrForbidden):
+		return codeForbidden, err.Error()
+	case errors.Is(err, repository.ErrMessageDeleted):
+		return codeGone, err.Error()
+	case errors.Is(err, repository.ErrInvalidParent):
+		return codeBadRequest, err.Error()
+	default:
+		log.Printf("Error trying to %s: %v", action, err)
+		return codeInternal, "failed to " + action
+	}
+}
+
+func (c *Client) sendError(req *request, roomID int, code, message string) {
+	c.sendFrame(req, "error", models.WSError{
+		Code:    code,
+		Message: message,
+		RoomID:  roomID,
+	})
+}
+
+// sendFailure reports err as an error frame; see classifyError.
+func (c *Client) sendFailure(req *request, roomID int, err error, action string) {
+	code, message := classifyError(err, action)
+	c.sendError(req, roomID, code, message)
+}
+
+// sendFrame queues a reply to this client, echoing the request id. Replies
+// bypass the resume hold since they answer the client's own request rather
+// than room traffic.
+func (c *Client) sendFrame(req *request, msgType string, payload interface{}) {
+	msg := models.WSMessage{
+		Type:    msgType,
+		Payload: payload,
+	}
+	if req != nil {
+		msg.ID = req.ID
+	}
+
+	data, err := newFrame(msg).encode(c.protocol.codec)
+	if err != nil {
+		return
+	}
+
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+	c.enqueue(data, msgType)
+}
+
+// frame is an outgoing server frame. It is encoded at most once per wire
+// format however many clients it is delivered to.
+type frame struct {
+	msg     models.WSMessage
+	once    [codecCount]sync.Once
+	encoded [codecCount][]byte
+	err     [codecCount]error
+}
+
+func newFrame(msg models.WSMessage) *frame {
+	return &frame{msg: msg}
+}
+
+// errNotRelayed is returned when encoding a relayed frame in a format the
+// publishing instance did not include.
+var errNotRelayed = errors.New("encoding not relayed")
+
+// newRelayedFrame wraps a frame another server instance already encoded in
+// every wire format. Only its type is known without decoding it.
+func newRelayedFrame(msgType string, encoded [codecCount][]byte) *frame {
+	f := &frame{msg: models.WSMessage{Type: msgType}}
+	for i := range f.once {
+		f.once[i].Do(func() {
+			f.encoded[i] = encoded[i]
+			if encoded[i] == nil {
+				f.err[i] = errNotRelayed
+			}
+		})
+	}
+	return f
+}
+
+func (f *frame) encode(wc *wireCodec) ([]byte, error) {
+	i := wc.index
+	f.once[i].Do(func() {
+		f.encoded[i], f.err[i] = wc.encode(f.msg)
+		if f.err[i] != nil {
+			log.Printf("Error encoding %s frame: %v", f.msg.Type, f.err[i])
+		}
+	})
+	return f.encoded[i], f.err[i]
+}
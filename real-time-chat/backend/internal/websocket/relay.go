@@ -0,0 +1,174 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"real-time-chat/internal/backplane"
+	"real-time-chat/internal/models"
+	"time"
+)
+
+// droppableEvents are the only frames that may be dropped on the way to the
+// backplane when it falls behind: a lost typing indicator goes stale on its
+// own, and presence is reloaded from the database every minute. Every other
+// event, including those without a frame such as subscribes and kicks,
+// waits for room in the queue and is retried if publishing fails, since
+// losing one would leave other instances with the wrong rooms or history.
+var droppableEvents = map[string]bool{
+	"typing":   true,
+	"presence": true,
+}
+
+const (
+	publishAttempts   = 5
+	publishRetryDelay = 100 * time.Millisecond
+)
+
+// relay passes an operation already applied on this instance on to the
+// others. Publishing happens on its own goroutine; a slow backplane stalls
+// callers only once the queue is full. Callers must not hold a shard lock.
+func (h *Hub) relay(event *backplane.Event, f *frame) {
+	if h.backplane == nil {
+		return
+	}
+
+	event.Origin = h.nodeID
+	if f != nil {
+		data, err := f.encode(jsonCodec)
+		if err != nil {
+			return
+		}
+		binary, err := f.encode(msgpackCodec)
+		if err != nil {
+			return
+		}
+		event.Type = f.msg.Type
+		event.Frame = data
+		event.BinaryFrame = binary
+	}
+
+	if !droppableEvents[event.Type] {
+		h.outbound <- event
+		return
+	}
+
+	select {
+	case h.outbound <- event:
+	default:
+		log.Printf("Backplane queue full, dropping %s event", event.Type)
+	}
+}
+
+func (h *Hub) publishLoop() {
+	for event := range h.outbound {
+		h.publish(event)
+	}
+}
+
+// publish sends an event, retrying all but droppable ones with a growing
+// delay.
+// Should the backplane stay down, the other instances lose their connection
+// to it too and tell their clients to resync.
+func (h *Hub) publish(event *backplane.Event) {
+	attempts := publishAttempts
+	if droppableEvents[event.Type] {
+		attempts = 1
+	}
+
+	delay := publishRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := h.backplane.Publish(event)
+		if err == nil {
+			return
+		}
+		if attempt == attempts {
+			log.Printf("Error publishing %s event to backplane: %v", event.Kind, err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// applyRemote repeats an operation published by another instance for the
+// connections held by this one.
+func (h *Hub) applyRemote(event *backplane.Event) {
+	if event.Origin == h.nodeID {
+		return
+	}
+
+	var f *frame
+	if len(event.Frame) > 0 {
+		var encoded [codecCount][]byte
+		encoded[jsonCodec.index] = event.Frame
+		encoded[msgpackCodec.index] = event.BinaryFrame
+		f = newRelayedFrame(event.Type, encoded)
+	}
+
+	switch event.Kind {
+	case backplane.KindSubscribe:
+		h.subscribeUser(event.RoomID, event.UserID)
+		return
+	case backplane.KindUnsubscribe:
+		h.removeUserFromRoom(event.RoomID, event.UserID)
+		return
+	case backplane.KindResync:
+		h.resyncRooms()
+		return
+	}
+
+	if f == nil {
+		log.Printf("Ignoring %s backplane event without a frame", event.Kind)
+		return
+	}
+	if event.Type == "presence" {
+		h.remotePresence(event.Frame)
+	}
+
+	switch event.Kind {
+	case backplane.KindRoom:
+		h.roomShard(event.RoomID).broadcast <- &BroadcastMessage{RoomID: event.RoomID, frame: f, excludeUserID: event.ExcludeUserID}
+	case backplane.KindUser:
+		h.deliverToUser(event.UserID, f)
+	case backplane.KindAll:
+		h.deliverToAll(f)
+	case backplane.KindCloseRoom:
+		h.roomShard(event.RoomID).broadcast <- &BroadcastMessage{RoomID: event.RoomID, frame: f, close: true}
+	default:
+		log.Printf("Ignoring unknown backplane event %q", event.Kind)
+	}
+}
+
+// resyncRooms tells every local client to refetch each of its rooms, after
+// events from other instances may have been lost.
+func (h *Hub) resyncRooms() {
+	var roomIDs []int
+	for _, rs := range h.rooms {
+		rs.mu.RLock()
+		for roomID := range rs.rooms {
+			roomIDs = append(roomIDs, roomID)
+		}
+		rs.mu.RUnlock()
+	}
+
+	log.Printf("Backplane events may have been lost, resyncing %d rooms", len(roomIDs))
+	for _, roomID := range roomIDs {
+		f := newFrame(models.WSMessage{
+			Type: "resync_required",
+			Payload: map[string]interface{}{
+				"room_id": roomID,
+			},
+		})
+		h.roomShard(roomID).broadcast <- &BroadcastMessage{RoomID: roomID, frame: f}
+	}
+}
+
+// newID returns a random identifier for this instance or a connection.
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Failed to generate ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,220 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"real-time-chat/internal/backplane"
+	"real-time-chat/internal/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTestHub starts the parts of a hub that need no database: the shard
+// workers and the backplane. Presence changes are discarded.
+func startTestHub(t testing.TB, bp backplane.Backplane) *Hub {
+	t.Helper()
+	h := NewHub(nil, nil, nil, bp, SlowConsumerPolicy{})
+	for _, shard := range h.rooms {
+		go shard.run()
+	}
+	if bp != nil {
+		go h.publishLoop()
+		if err := bp.Subscribe(h.applyRemote); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go func() {
+		for range h.presence {
+		}
+	}()
+	return h
+}
+
+// newTestClient returns a version 2 JSON client without a connection; its
+// frames are read straight from the send buffer.
+func newTestClient(h *Hub, userID int) *Client {
+	return &Client{
+		hub:      h,
+		send:     make(chan []byte, h.policy.SendBuffer),
+		done:     make(chan struct{}),
+		UserID:   userID,
+		Username: fmt.Sprintf("user%d", userID),
+		ConnID:   newID(),
+		protocol: protocolV2,
+		rooms:    make(map[int]bool),
+	}
+}
+
+type testFrame struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func nextFrame(t *testing.T, c *Client) testFrame {
+	t.Helper()
+	select {
+	case data := <-c.send:
+		var f testFrame
+		if err := json.Unmarshal(data, &f); err != nil {
+			t.Fatalf("decoding frame: %v", err)
+		}
+		return f
+	case <-time.After(time.Second):
+		t.Fatalf("user %d received no frame", c.UserID)
+		return testFrame{}
+	}
+}
+
+func expectFrame(t *testing.T, c *Client, msgType string) testFrame {
+	t.Helper()
+	f := nextFrame(t, c)
+	if f.Type != msgType {
+		t.Fatalf("user %d got %s frame, want %s", c.UserID, f.Type, msgType)
+	}
+	return f
+}
+
+func expectNoFrame(t *testing.T, c *Client) {
+	t.Helper()
+	select {
+	case data := <-c.send:
+		t.Fatalf("user %d got unexpected frame %s", c.UserID, data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestResyncRoomsAfterLostEvents(t *testing.T) {
+	h := startTestHub(t, nil)
+	a := newTestClient(h, 1)
+	b := newTestClient(h, 2)
+	h.Register(a)
+	h.Register(b)
+	h.Subscribe(a, 10)
+	h.Subscribe(a, 20)
+	h.Subscribe(b, 20)
+
+	h.applyRemote(&backplane.Event{Kind: backplane.KindResync})
+
+	tests := []struct {
+		client *Client
+		rooms  []int
+	}{
+		{a, []int{10, 20}},
+		{b, []int{20}},
+	}
+	for _, tt := range tests {
+		got := make(map[int]bool)
+		for range tt.rooms {
+			var payload struct {
+				RoomID int `json:"room_id"`
+			}
+			f := expectFrame(t, tt.client, "resync_required")
+			if err := json.Unmarshal(f.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			got[payload.RoomID] = true
+		}
+		for _, roomID := range tt.rooms {
+			if !got[roomID] {
+				t.Errorf("user %d got no resync for room %d", tt.client.UserID, roomID)
+			}
+		}
+		expectNoFrame(t, tt.client)
+	}
+}
+
+func TestRelayWaitsForReliableFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		event *backplane.Event
+		f     *frame
+	}{
+		{"new_message", &backplane.Event{Kind: backplane.KindRoom}, newFrame(models.WSMessage{Type: "new_message"})},
+		{"message_deleted", &backplane.Event{Kind: backplane.KindRoom}, newFrame(models.WSMessage{Type: "message_deleted"})},
+		{"kick", &backplane.Event{Kind: backplane.KindUnsubscribe, RoomID: 1, UserID: 2}, nil},
+	}
+
+	for _, tt := range tests {
+		h := NewHub(nil, nil, nil, backplane.NewMemory(), SlowConsumerPolicy{})
+		h.outbound = make(chan *backplane.Event, 1)
+
+		typing := func() *frame { return newFrame(models.WSMessage{Type: "typing"}) }
+		h.relay(&backplane.Event{Kind: backplane.KindRoom}, typing())
+		h.relay(&backplane.Event{Kind: backplane.KindRoom}, typing())
+		if n := len(h.outbound); n != 1 {
+			t.Fatalf("outbound holds %d events, want 1 with the second typing frame dropped", n)
+		}
+
+		relayed := make(chan struct{})
+		go func() {
+			h.relay(tt.event, tt.f)
+			close(relayed)
+		}()
+
+		select {
+		case <-relayed:
+			t.Fatalf("%s relayed into a full queue", tt.name)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		<-h.outbound
+		select {
+		case <-relayed:
+		case <-time.After(time.Second):
+			t.Fatalf("%s still waiting after the queue drained", tt.name)
+		}
+		if event := <-h.outbound; event != tt.event {
+			t.Errorf("queued %s %q event, want %s", event.Kind, event.Type, tt.name)
+		}
+	}
+}
+
+// flakyBackplane fails its first few publishes, then succeeds.
+type flakyBackplane struct {
+	mu        sync.Mutex
+	failures  int
+	published int
+}
+
+func (b *flakyBackplane) Publish(event *backplane.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published++
+	if b.published <= b.failures {
+		return errors.New("backplane unavailable")
+	}
+	return nil
+}
+
+func (b *flakyBackplane) Subscribe(handler func(*backplane.Event)) error { return nil }
+func (b *flakyBackplane) Close() error                                   { return nil }
+
+func TestPublishRetriesReliableFrames(t *testing.T) {
+	tests := []struct {
+		kind     string
+		msgType  string
+		failures int
+		want     int
+	}{
+		{backplane.KindRoom, "new_message", 0, 1},
+		{backplane.KindRoom, "new_message", 2, 3},
+		{backplane.KindRoom, "thread_reply", 1, 2},
+		{backplane.KindRoom, "message_updated", 1, 2},
+		{backplane.KindUser, "member_removed", 1, 2},
+		{backplane.KindUnsubscribe, "", 1, 2},
+		{backplane.KindCloseRoom, "room_deleted", 2, 3},
+		{backplane.KindRoom, "typing", 2, 1},
+		{backplane.KindAll, "presence", 1, 1},
+	}
+
+	for _, tt := range tests {
+		bp := &flakyBackplane{failures: tt.failures}
+		h := NewHub(nil, nil, nil, bp, SlowConsumerPolicy{})
+		h.publish(&backplane.Event{Kind: tt.kind, Type: tt.msgType})
+		if bp.published != tt.want {
+			t.Errorf("%s %q after %d failures: published %d times, want %d", tt.kind, tt.msgType, tt.failures, bp.published, tt.want)
+		}
+	}
+}
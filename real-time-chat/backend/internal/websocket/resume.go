@@ -0,0 +1,265 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"real-time-chat/internal/models"
+)
+
+const (
+	// maxReplayMessages bounds how much history a reconnecting client is
+	// sent per room before it is told to refetch instead. Across all rooms
+	// a resume replays at most half the slow-consumer backlog, leaving the
+	// rest for the live traffic held meanwhile.
+	maxReplayMessages = 200
+
+	// maxHeldFrames bounds the live frames buffered while a replay runs. A
+	// client with more waiting is disconnected as a slow consumer, since the
+	// frames it would lose are not limited to the rooms being resumed.
+	maxHeldFrames = 1024
+)
+
+// trySend queues a frame for the client without blocking, applying the
+// slow-consumer policy if it is behind. Frames the client's protocol
+// version does not know are skipped. While a resume is in progress live
+// frames are held back so they are delivered after the replayed history
+// rather than interleaved with it.
+func (c *Client) trySend(f *frame) {
+	if !c.protocol.Supports(f.msg.Type) {
+		return
+	}
+
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+
+	if c.isClosed() {
+		return
+	}
+	if c.holding > 0 {
+		if len(c.held) >= maxHeldFrames {
+			c.held = nil
+			c.disconnectSlow()
+			return
+		}
+		c.held = append(c.held, f)
+		return
+	}
+
+	data, err := f.encode(c.protocol.codec)
+	if err != nil {
+		return
+	}
+	c.enqueue(data, f.msg.Type)
+}
+
+func (c *Client) beginHold() {
+	c.holdMu.Lock()
+	c.holding++
+	c.holdMu.Unlock()
+}
+
+// endHold releases held live frames, skipping new messages the replay
+// already delivered.
+func (c *Client) endHold(replayed map[int]bool) {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+
+	c.holding--
+	if c.holding > 0 {
+		return
+	}
+
+	for _, f := range c.held {
+		if isReplayedMessage(f, replayed) {
+			continue
+		}
+		data, err := f.encode(c.protocol.codec)
+		if err != nil {
+			continue
+		}
+		c.enqueue(data, f.msg.Type)
+	}
+	c.held = nil
+}
+
+func isReplayedMessage(f *frame, replayed map[int]bool) bool {
+	if f.msg.Type != "new_message" && f.msg.Type != "thread_reply" {
+		return false
+	}
+	if message, ok := f.msg.Payload.(*models.Message); ok {
+		return replayed[message.ID]
+	}
+
+	// Frames relayed from other instances carry only their encoding
+	data, err := f.encode(jsonCodec)
+	if err != nil {
+		return false
+	}
+	var relayed struct {
+		Payload struct {
+			ID int `json:"id"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &relayed); err != nil {
+		return false
+	}
+	return replayed[relayed.Payload.ID]
+}
+
+// sendReplay queues a replayed frame behind anything already backlogged for
+// the client. Replayed frames are never disposable, so a client too slow to
+// take the replay is disconnected rather than silently missing history; it
+// reports false once the client is closed.
+func (c *Client) sendReplay(message models.WSMessage) bool {
+	data, err := newFrame(message).encode(c.protocol.codec)
+	if err != nil {
+		return false
+	}
+
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+	c.enqueue(data, message.Type)
+	return !c.isClosed()
+}
+
+func (c *Client) handleResume(req *request) {
+	var resume models.Resume
+	if !c.decode(req, &resume) {
+		return
+	}
+
+	var rooms []models.ResumeRoom
+	for _, room := range resume.Rooms {
+		if _, err := c.authorizer.CanJoin(c.UserID, room.RoomID); err != nil {
+			c.sendFailure(req, room.RoomID, err, "check permissions")
+			continue
+		}
+		if err := c.roomRepo.AddMember(room.RoomID, c.UserID); err != nil {
+			c.sendFailure(req, room.RoomID, err, "join room")
+			continue
+		}
+		rooms = append(rooms, room)
+	}
+
+	c.resumeRooms(rooms, false)
+}
+
+// resumeRooms subscribes the client to the rooms and replays what it missed
+// since each room's last seen message, holding live traffic until the
+// replay is done. announce controls whether the room sees a user_joined.
+func (c *Client) resumeRooms(rooms []models.ResumeRoom, announce bool) {
+	c.beginHold()
+
+	for _, room := range rooms {
+		if announce {
+			c.hub.JoinRoom(c, room.RoomID)
+		} else {
+			c.hub.Subscribe(c, room.RoomID)
+		}
+	}
+
+	replayed := make(map[int]bool)
+	budget := c.hub.policy.Backlog / 2
+	var resync []int
+	for _, room := range rooms {
+		sent, ok := c.replayRoom(room, replayed, budget)
+		budget -= sent
+		if !ok {
+			resync = append(resync, room.RoomID)
+		}
+	}
+
+	c.endHold(replayed)
+
+	for _, roomID := range resync {
+		c.sendReplay(models.WSMessage{
+			Type: "resync_required",
+			Payload: map[string]interface{}{
+				"room_id": roomID,
+			},
+		})
+	}
+
+	roomIDs := make([]int, 0, len(rooms))
+	for _, room := range rooms {
+		roomIDs = append(roomIDs, room.RoomID)
+	}
+	c.sendReplay(models.WSMessage{
+		Type: "resume_complete",
+		Payload: map[string]interface{}{
+			"room_ids": roomIDs,
+		},
+	})
+}
+
+// replayRoom sends the messages posted, edited or deleted since the client's
+// last seen message, but no more than budget of them, and returns how many
+// it sent. It reports false when the gap is too large to replay and the
+// client should refetch the room instead.
+func (c *Client) replayRoom(room models.ResumeRoom, replayed map[int]bool, budget int) (int, bool) {
+	if room.LastMessageID <= 0 {
+		return 0, true
+	}
+
+	limit := maxReplayMessages
+	if budget < limit {
+		limit = budget
+	}
+
+	missed, err := c.messageRepo.GetSince(room.RoomID, room.LastMessageID, limit+1)
+	if err != nil {
+		log.Printf("Error loading missed messages: %v", err)
+		return 0, false
+	}
+	if len(missed) > limit {
+		return 0, false
+	}
+
+	changed, err := c.messageRepo.GetChangedSince(room.RoomID, room.LastMessageID, limit-len(missed)+1)
+	if err != nil {
+		log.Printf("Error loading changed messages: %v", err)
+		return 0, false
+	}
+	if len(missed)+len(changed) > limit {
+		return 0, false
+	}
+
+	sent := 0
+	for _, message := range changed {
+		if !c.sendReplay(changeEvent(message)) {
+			return sent, false
+		}
+		sent++
+	}
+
+	for _, message := range missed {
+		eventType := "new_message"
+		if message.ParentID != nil {
+			eventType = "thread_reply"
+		}
+		if !c.sendReplay(models.WSMessage{Type: eventType, Payload: message}) {
+			return sent, false
+		}
+		sent++
+		replayed[message.ID] = true
+	}
+
+	return sent, true
+}
+
+func changeEvent(message *models.Message) models.WSMessage {
+	if message.IsDeleted {
+		return models.WSMessage{
+			Type: "message_deleted",
+			Payload: map[string]interface{}{
+				"message_id": message.ID,
+				"room_id":    message.RoomID,
+				"deleted_by": message.DeletedBy,
+			},
+		}
+	}
+	return models.WSMessage{
+		Type:    "message_updated",
+		Payload: message,
+	}
+}
@@ -0,0 +1,45 @@
+package websocket
+
+import (
+	"real-time-chat/internal/models"
+	"testing"
+)
+
+func TestHeldFrames(t *testing.T) {
+	tests := []struct {
+		name       string
+		frames     int
+		replayed   map[int]bool
+		wantSent   int
+		wantClosed bool
+	}{
+		{"released after the replay", 3, nil, 3, false},
+		{"replayed messages skipped", 3, map[int]bool{1: true, 3: true}, 1, false},
+		{"full hold", maxHeldFrames, nil, maxHeldFrames, false},
+		{"overflow disconnects", maxHeldFrames + 1, nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		h := NewHub(nil, nil, nil, nil, SlowConsumerPolicy{SendBuffer: 2 * maxHeldFrames})
+		c := newTestClient(h, 1)
+
+		c.beginHold()
+		for id := 1; id <= tt.frames; id++ {
+			c.trySend(newFrame(models.WSMessage{Type: "new_message", Payload: &models.Message{ID: id}}))
+		}
+		if n := len(c.send); n != 0 {
+			t.Errorf("%s: %d frames sent while holding", tt.name, n)
+		}
+		c.endHold(tt.replayed)
+
+		if n := len(c.send); n != tt.wantSent {
+			t.Errorf("%s: sent %d frames, want %d", tt.name, n, tt.wantSent)
+		}
+		if closed := c.isClosed(); closed != tt.wantClosed {
+			t.Errorf("%s: closed = %v, want %v", tt.name, closed, tt.wantClosed)
+		}
+		if tt.wantClosed && c.closeCode != closeSlowConsumer {
+			t.Errorf("%s: close code %d, want %d", tt.name, c.closeCode, closeSlowConsumer)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package websocket
+
+import "sync"
+
+// hubShards is the number of independently locked partitions of rooms and
+// of connected users. Rooms and users are assigned to shards by ID, so
+// traffic for different rooms rarely contends on the same lock.
+const hubShards = 32
+
+// roomShardQueueSize bounds the broadcasts waiting for a shard's worker.
+const roomShardQueueSize = 256
+
+// roomShard holds the subscribers of the rooms that map to it. Every frame
+// sent to a room, from messages to joins, leaves, typing and the room being
+// closed, goes through its worker, which delivers them in the order they
+// were queued, so every room keeps a single ordered stream.
+type roomShard struct {
+	mu        sync.RWMutex
+	rooms     map[int]map[*Client]bool
+	broadcast chan *BroadcastMessage
+}
+
+func newRoomShard() *roomShard {
+	return &roomShard{
+		rooms:     make(map[int]map[*Client]bool),
+		broadcast: make(chan *BroadcastMessage, roomShardQueueSize),
+	}
+}
+
+// add subscribes a client to a room. The caller holds the shard lock. It
+// reports false when the client has already unregistered.
+func (s *roomShard) add(client *Client, roomID int) bool {
+	if !client.addRoom(roomID) {
+		return false
+	}
+	if s.rooms[roomID] == nil {
+		s.rooms[roomID] = make(map[*Client]bool)
+	}
+	s.rooms[roomID][client] = true
+	return true
+}
+
+// remove unsubscribes a client from a room. The caller holds the shard
+// lock.
+func (s *roomShard) remove(client *Client, roomID int) {
+	clients, ok := s.rooms[roomID]
+	if !ok {
+		return
+	}
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(s.rooms, roomID)
+	}
+	client.removeRoom(roomID)
+}
+
+// run delivers queued broadcasts. Clients that cannot keep up are dealt
+// with by the slow-consumer policy rather than here.
+func (s *roomShard) run() {
+	for message := range s.broadcast {
+		if message.close {
+			s.mu.Lock()
+			s.deliver(message)
+			for client := range s.rooms[message.RoomID] {
+				s.remove(client, message.RoomID)
+			}
+			s.mu.Unlock()
+			continue
+		}
+
+		s.mu.RLock()
+		s.deliver(message)
+		s.mu.RUnlock()
+	}
+}
+
+// deliver sends a broadcast to the room's clients other than those it
+// excludes. The caller holds the shard lock.
+func (s *roomShard) deliver(message *BroadcastMessage) {
+	for client := range s.rooms[message.RoomID] {
+		if client == message.exclude || client.UserID == message.excludeUserID {
+			continue
+		}
+		client.trySend(message.frame)
+	}
+}
+
+// userShard holds the live connections of the users that map to it.
+type userShard struct {
+	mu      sync.RWMutex
+	clients map[int]map[*Client]bool
+}
+
+func newUserShard() *userShard {
+	return &userShard{clients: make(map[int]map[*Client]bool)}
+}
+
+func (h *Hub) roomShard(roomID int) *roomShard {
+	return h.rooms[uint(roomID)%hubShards]
+}
+
+func (h *Hub) userShard(userID int) *userShard {
+	return h.users[uint(userID)%hubShards]
+}
+
+// userClients returns a snapshot of a user's connections.
+func (h *Hub) userClients(userID int) []*Client {
+	us := h.userShard(userID)
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	clients := make([]*Client, 0, len(us.clients[userID]))
+	for client := range us.clients[userID] {
+		clients = append(clients, client)
+	}
+	return clients
+}
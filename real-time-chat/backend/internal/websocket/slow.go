@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"expvar"
+	"log"
+	"time"
+)
+
+// closeSlowConsumer is the close code sent to a client that could not keep
+// up with its traffic. It should resume to recover anything it missed.
+const closeSlowConsumer = 4008
+
+// SlowConsumerPolicy decides what happens when a client reads more slowly
+// than frames are produced for it.
+//
+// Frames are queued in a buffer of SendBuffer frames. Once it is full,
+// disposable frames such as typing indicators are dropped, and everything
+// else waits in a backlog of up to Backlog frames. A client still backed up
+// after Grace, or whose backlog overflows, is disconnected with
+// closeSlowConsumer rather than silently losing messages.
+type SlowConsumerPolicy struct {
+	SendBuffer int
+	Backlog    int
+	Grace      time.Duration
+}
+
+var defaultSlowConsumerPolicy = SlowConsumerPolicy{
+	SendBuffer: 256,
+	Backlog:    1024,
+	Grace:      10 * time.Second,
+}
+
+// withDefaults fills unset fields from the default policy.
+func (p SlowConsumerPolicy) withDefaults() SlowConsumerPolicy {
+	if p.SendBuffer <= 0 {
+		p.SendBuffer = defaultSlowConsumerPolicy.SendBuffer
+	}
+	if p.Backlog <= 0 {
+		p.Backlog = defaultSlowConsumerPolicy.Backlog
+	}
+	if p.Grace <= 0 {
+		p.Grace = defaultSlowConsumerPolicy.Grace
+	}
+	return p
+}
+
+// disposableFrames may be dropped for a slow client; losing one only leaves
+// a stale indicator that the next event corrects.
+var disposableFrames = map[string]bool{
+	"typing": true,
+}
+
+// Counters published on /debug/vars
+var (
+	framesDropped    = expvar.NewMap("ws_frames_dropped")
+	framesBacklogged = expvar.NewInt("ws_frames_backlogged")
+	slowDisconnects  = expvar.NewInt("ws_slow_disconnects")
+)
+
+// enqueue queues an encoded frame for the write pump according to the
+// hub's slow-consumer policy. The caller holds holdMu.
+func (c *Client) enqueue(data []byte, msgType string) {
+	if c.isClosed() {
+		return
+	}
+
+	// Frames only go straight to the buffer while nothing is backlogged,
+	// otherwise they would overtake it
+	if len(c.backlog) == 0 {
+		select {
+		case c.send <- data:
+			return
+		default:
+		}
+	}
+
+	if disposableFrames[msgType] {
+		framesDropped.Add(msgType, 1)
+		return
+	}
+
+	now := time.Now()
+	if len(c.backlog) == 0 {
+		c.slowSince = now
+	}
+	if len(c.backlog) >= c.hub.policy.Backlog || now.Sub(c.slowSince) > c.hub.policy.Grace {
+		c.disconnectSlow()
+		return
+	}
+	c.backlog = append(c.backlog, data)
+	framesBacklogged.Add(1)
+}
+
+// refill moves backlogged frames into the send buffer as the write pump
+// frees space, and disconnects the client once it has been behind for
+// longer than the grace period.
+func (c *Client) refill() {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+
+	if len(c.backlog) == 0 {
+		return
+	}
+
+	n := 0
+fill:
+	for ; n < len(c.backlog); n++ {
+		select {
+		case c.send <- c.backlog[n]:
+		default:
+			break fill
+		}
+	}
+	c.backlog = c.backlog[n:]
+
+	if len(c.backlog) == 0 {
+		c.backlog = nil
+		return
+	}
+	if time.Since(c.slowSince) > c.hub.policy.Grace {
+		c.disconnectSlow()
+	}
+}
+
+// disconnectSlow closes a client that fell too far behind. The caller holds
+// holdMu.
+func (c *Client) disconnectSlow() {
+	if c.isClosed() {
+		return
+	}
+	log.Printf("Disconnecting slow client: %s (ID: %d), %d frames behind", c.Username, c.UserID, len(c.backlog)+len(c.send))
+	slowDisconnects.Add(1)
+	c.backlog = nil
+	c.close(closeSlowConsumer, "too slow")
+}
+
+// close stops the write pump, which sends a close frame with the given code
+// and reason. Only the first call has any effect. The send channel itself
+// is never closed, so late senders cannot panic.
+func (c *Client) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.done)
+	})
+}
+
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
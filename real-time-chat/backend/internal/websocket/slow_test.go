@@ -0,0 +1,169 @@
+package websocket
+
+import (
+	"encoding/json"
+	"real-time-chat/internal/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSlowConsumerPolicyDefaults(t *testing.T) {
+	tests := []struct {
+		policy SlowConsumerPolicy
+		want   SlowConsumerPolicy
+	}{
+		{SlowConsumerPolicy{}, defaultSlowConsumerPolicy},
+		{SlowConsumerPolicy{SendBuffer: -1, Backlog: -1, Grace: -1}, defaultSlowConsumerPolicy},
+		{
+			SlowConsumerPolicy{SendBuffer: 8},
+			SlowConsumerPolicy{SendBuffer: 8, Backlog: 1024, Grace: 10 * time.Second},
+		},
+		{
+			SlowConsumerPolicy{SendBuffer: 1, Backlog: 2, Grace: time.Second},
+			SlowConsumerPolicy{SendBuffer: 1, Backlog: 2, Grace: time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.withDefaults(); got != tt.want {
+			t.Errorf("%+v.withDefaults() = %+v, want %+v", tt.policy, got, tt.want)
+		}
+	}
+}
+
+// newSlowClient returns a client whose send buffer holds two frames and
+// whose backlog holds three.
+func newSlowClient() *Client {
+	h := NewHub(nil, nil, nil, nil, SlowConsumerPolicy{SendBuffer: 2, Backlog: 3, Grace: time.Hour})
+	return newTestClient(h, 1)
+}
+
+func enqueueAll(c *Client, types ...string) {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+	for _, msgType := range types {
+		c.enqueue([]byte(msgType), msgType)
+	}
+}
+
+// drain reads the send buffer dry, refilling it from the backlog the way
+// the write pump does.
+func drain(c *Client) []string {
+	var got []string
+	for {
+		select {
+		case data := <-c.send:
+			got = append(got, string(data))
+			c.refill()
+		default:
+			return got
+		}
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	tests := []struct {
+		name        string
+		frames      []string
+		wantSent    []string
+		wantBacklog int
+		wantClosed  bool
+	}{
+		{"fits the buffer", []string{"a", "b"}, []string{"a", "b"}, 0, false},
+		{"typing dropped when full", []string{"a", "b", "typing"}, []string{"a", "b"}, 0, false},
+		{"backlogged when full", []string{"a", "b", "c", "d"}, []string{"a", "b"}, 2, false},
+		{"typing dropped behind a backlog", []string{"a", "b", "c", "typing"}, []string{"a", "b"}, 1, false},
+		{"backlog overflow disconnects", []string{"a", "b", "c", "d", "e", "f"}, []string{"a", "b"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		c := newSlowClient()
+		enqueueAll(c, tt.frames...)
+
+		var sent []string
+		for len(c.send) > 0 {
+			sent = append(sent, string(<-c.send))
+		}
+		if !reflect.DeepEqual(sent, tt.wantSent) {
+			t.Errorf("%s: sent %v, want %v", tt.name, sent, tt.wantSent)
+		}
+		if len(c.backlog) != tt.wantBacklog {
+			t.Errorf("%s: backlog holds %d frames, want %d", tt.name, len(c.backlog), tt.wantBacklog)
+		}
+		if c.isClosed() != tt.wantClosed {
+			t.Errorf("%s: closed = %v, want %v", tt.name, c.isClosed(), tt.wantClosed)
+		}
+		if tt.wantClosed && c.closeCode != closeSlowConsumer {
+			t.Errorf("%s: close code %d, want %d", tt.name, c.closeCode, closeSlowConsumer)
+		}
+	}
+}
+
+// A frame queued while others are backlogged waits behind them even when
+// the send buffer has room.
+func TestRefillKeepsOrder(t *testing.T) {
+	c := newSlowClient()
+	enqueueAll(c, "a", "b", "c", "d")
+
+	first := <-c.send
+	enqueueAll(c, "e")
+	if len(c.send) != 1 {
+		t.Fatalf("frame overtook the backlog: send holds %d frames", len(c.send))
+	}
+
+	got := append([]string{string(first)}, drain(c)...)
+	if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+	if c.backlog != nil {
+		t.Errorf("backlog not released after draining: %v", c.backlog)
+	}
+}
+
+func TestRefillDisconnectsAfterGrace(t *testing.T) {
+	c := newSlowClient()
+	enqueueAll(c, "a", "b", "c")
+
+	c.refill()
+	if c.isClosed() {
+		t.Fatal("disconnected within the grace period")
+	}
+
+	c.slowSince = time.Now().Add(-2 * time.Hour)
+	c.refill()
+	if !c.isClosed() || c.closeCode != closeSlowConsumer {
+		t.Errorf("closed = %v with code %d, want closed with %d", c.isClosed(), c.closeCode, closeSlowConsumer)
+	}
+}
+
+func TestSendReplayQueuesBehindBacklog(t *testing.T) {
+	c := newSlowClient()
+	enqueueAll(c, "a", "b", "c")
+	first := <-c.send
+
+	if !c.sendReplay(models.WSMessage{Type: "resume_complete"}) {
+		t.Fatal("sendReplay failed for a client within its backlog")
+	}
+
+	got := append([]string{string(first)}, drain(c)...)
+	if len(got) != 4 || !reflect.DeepEqual(got[:3], []string{"a", "b", "c"}) {
+		t.Fatalf("delivered %v, want a, b, c and then the replayed frame", got)
+	}
+	var replayed testFrame
+	if err := json.Unmarshal([]byte(got[3]), &replayed); err != nil || replayed.Type != "resume_complete" {
+		t.Errorf("last frame = %s, want resume_complete", got[3])
+	}
+}
+
+func TestSendReplayReportsDisconnect(t *testing.T) {
+	c := newSlowClient()
+	enqueueAll(c, "a", "b", "c", "d", "e")
+
+	if c.sendReplay(models.WSMessage{Type: "new_message"}) {
+		t.Error("sendReplay succeeded past a full backlog")
+	}
+	if c.closeCode != closeSlowConsumer {
+		t.Errorf("close code %d, want %d", c.closeCode, closeSlowConsumer)
+	}
+}
@@ -0,0 +1,124 @@
+package websocket
+
+// Protocol is a version of the WebSocket protocol a client can negotiate
+// through the Sec-WebSocket-Protocol header.
+type Protocol struct {
+	Name    string
+	Version int
+
+	// Features are advertised to the client in the welcome frame.
+	Features []string
+
+	// events restricts the server frames sent to clients of this version;
+	// nil allows every type. Frames introduced after a version are never
+	// sent to its clients.
+	events map[string]bool
+
+	// replaced lists older frame types that clients of this version receive
+	// in another form instead.
+	replaced map[string]bool
+
+	// batch packs several frames into one WebSocket message separated by
+	// newlines, as the first version of the protocol did.
+	batch bool
+
+	codec *wireCodec
+}
+
+// protocolV1 is the original protocol. Clients that do not request a
+// subprotocol get it, so they keep working unchanged.
+var protocolV1 = &Protocol{
+	Name:    "chat.v1",
+	Version: 1,
+	events: eventSet(
+		"new_message", "message_ack", "message_error", "thread_reply", "thread_updated",
+		"message_updated", "message_deleted", "reaction_updated", "read_receipt", "mention",
+		"resume_complete", "resync_required", "user_joined", "user_left", "online_users",
+		"typing", "room_invite", "room_updated", "room_deleted", "member_role_updated",
+		"member_removed", "error",
+	),
+	batch: true,
+	codec: jsonCodec,
+}
+
+// featuresV2 are shared by both encodings of version 2.
+var featuresV2 = []string{
+	"request_ids", "error_codes", "message_acks", "resume", "seq",
+	"threads", "reactions", "read_receipts", "mentions", "presence",
+}
+
+// replacedV2 are version 1 frames superseded in version 2: presence frames
+// carry changes instead of the whole online_users list.
+var replacedV2 = eventSet("online_users")
+
+// protocolV2 sends one frame per WebSocket message and opens with a
+// welcome frame describing the server.
+var protocolV2 = &Protocol{
+	Name:     "chat.v2",
+	Version:  2,
+	Features: featuresV2,
+	replaced: replacedV2,
+	codec:    jsonCodec,
+}
+
+// protocolV2Msgpack is version 2 with MessagePack binary frames, for
+// clients where JSON parsing and payload size matter.
+var protocolV2Msgpack = &Protocol{
+	Name:     "chat.v2.msgpack",
+	Version:  2,
+	Features: featuresV2,
+	replaced: replacedV2,
+	codec:    msgpackCodec,
+}
+
+// protocols lists the supported versions, most preferred first. A client
+// that offers MessagePack gets it.
+var protocols = []*Protocol{protocolV2Msgpack, protocolV2, protocolV1}
+
+// Subprotocols returns the subprotocol names the server accepts, in order
+// of preference, for use in the WebSocket upgrader.
+func Subprotocols() []string {
+	names := make([]string, 0, len(protocols))
+	for _, p := range protocols {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// protocolFor returns the protocol selected during the upgrade. An empty
+// name means the client did not ask for one and speaks version 1.
+func protocolFor(name string) *Protocol {
+	for _, p := range protocols {
+		if p.Name == name {
+			return p
+		}
+	}
+	return protocolV1
+}
+
+// Supports reports whether clients of this version understand the given
+// server frame type.
+func (p *Protocol) Supports(msgType string) bool {
+	if p.replaced[msgType] {
+		return false
+	}
+	return p.events == nil || p.events[msgType]
+}
+
+// welcome describes the negotiated protocol to a newly connected client.
+func (p *Protocol) welcome() map[string]interface{} {
+	return map[string]interface{}{
+		"protocol":  p.Name,
+		"version":   p.Version,
+		"features":  p.Features,
+		"supported": Subprotocols(),
+	}
+}
+
+func eventSet(types ...string) map[string]bool {
+	set := make(map[string]bool, len(types))
+	for _, t := range types {
+		set[t] = true
+	}
+	return set
+}
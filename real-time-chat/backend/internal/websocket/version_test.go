@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProtocolFor(t *testing.T) {
+	tests := []struct {
+		name string
+		want *Protocol
+	}{
+		{"", protocolV1},
+		{"chat.v1", protocolV1},
+		{"chat.v2", protocolV2},
+		{"chat.v2.msgpack", protocolV2Msgpack},
+		{"chat.v3", protocolV1},
+		{"CHAT.V2", protocolV1},
+	}
+
+	for _, tt := range tests {
+		if got := protocolFor(tt.name); got != tt.want {
+			t.Errorf("protocolFor(%q) = %s, want %s", tt.name, got.Name, tt.want.Name)
+		}
+	}
+}
+
+func TestSubprotocolsPreferMsgpack(t *testing.T) {
+	want := []string{"chat.v2.msgpack", "chat.v2", "chat.v1"}
+	if got := Subprotocols(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Subprotocols() = %v, want %v", got, want)
+	}
+}
+
+func TestSupports(t *testing.T) {
+	tests := []struct {
+		protocol *Protocol
+		msgType  string
+		want     bool
+	}{
+		{protocolV1, "new_message", true},
+		{protocolV1, "online_users", true},
+		{protocolV1, "typing", true},
+		{protocolV1, "welcome", false},
+		{protocolV1, "presence", false},
+		{protocolV1, "presence_snapshot", false},
+		{protocolV2, "new_message", true},
+		{protocolV2, "welcome", true},
+		{protocolV2, "presence", true},
+		{protocolV2, "online_users", false},
+		{protocolV2Msgpack, "presence_snapshot", true},
+		{protocolV2Msgpack, "online_users", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.protocol.Supports(tt.msgType); got != tt.want {
+			t.Errorf("%s.Supports(%q) = %v, want %v", tt.protocol.Name, tt.msgType, got, tt.want)
+		}
+	}
+}
@@ -26,8 +26,8 @@ export default function ChatRoom({ room }) {
   const loadMessages = async () => {
     setLoading(true)
     try {
-      const data = await api.getRoomMessages(room.id)
-      addMessageToRoom(room.id, data)
+      const page = await api.getRoomMessages(room.id)
+      addMessageToRoom(room.id, page.messages)
     } catch (error) {
       console.error('Failed to load messages:', error)
     } finally {
@@ -101,8 +101,12 @@ export default function ChatRoom({ room }) {
                   <div className="msg-content">
                     {!isOwn && <span className="msg-username">{msg.username}</span>}
                     <div className="msg-bubble">
-                      <p>{msg.content}</p>
-                      <span className="msg-time">{formatTime(msg.created_at)}</span>
+                      {msg.is_deleted
+                        ? <p className="msg-deleted">Message deleted</p>
+                        : <p>{msg.content}</p>}
+                      <span className="msg-time">
+                        {formatTime(msg.created_at)}{msg.edited_at && !msg.is_deleted ? ' (edited)' : ''}
+                      </span>
                     </div>
                   </div>
                 </div>
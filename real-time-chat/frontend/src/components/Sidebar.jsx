@@ -50,8 +50,13 @@ export default function Sidebar({ rooms, selectedRoom, onSelectRoom, onCreateRoo
           <div className="users-list">
             {onlineUsers?.map(u => (
               <div key={u.id} className="user-item">
-                <div className="user-avatar">{u.username.charAt(0).toUpperCase()}<span className="status-indicator"></span></div>
-                <span className="user-name">{u.username}{u.id === user?.id && ' (You)'}</span>
+                <div className="user-avatar">{u.username.charAt(0).toUpperCase()}<span className={`status-indicator ${u.status || 'online'}`}></span></div>
+                <span className="user-name">
+                  {u.username}{u.id === user?.id && ' (You)'}
+                  {(u.status_emoji || u.status_text) && (
+                    <span className="user-status-text"> {u.status_emoji} {u.status_text}</span>
+                  )}
+                </span>
               </div>
             ))}
           </div>
@@ -21,7 +21,7 @@ export function AuthProvider({ children }) {
       const userData = await api.getCurrentUser()
       setUser(userData)
     } catch (error) {
-      localStorage.removeItem('token')
+      api.clearTokens()
       localStorage.removeItem('user')
     } finally {
       setLoading(false)
@@ -30,7 +30,7 @@ export function AuthProvider({ children }) {
 
   const login = async (email, password) => {
     const response = await api.login(email, password)
-    localStorage.setItem('token', response.token)
+    api.setTokens(response)
     localStorage.setItem('user', JSON.stringify(response.user))
     setUser(response.user)
     return response
@@ -38,14 +38,19 @@ export function AuthProvider({ children }) {
 
   const register = async (username, email, password) => {
     const response = await api.register(username, email, password)
-    localStorage.setItem('token', response.token)
+    api.setTokens(response)
     localStorage.setItem('user', JSON.stringify(response.user))
     setUser(response.user)
     return response
   }
 
-  const logout = () => {
-    localStorage.removeItem('token')
+  const logout = async () => {
+    try {
+      await api.logout()
+    } catch (error) {
+      // The session may already be gone server-side; clear local state anyway
+    }
+    api.clearTokens()
     localStorage.removeItem('user')
     setUser(null)
   }
@@ -1,8 +1,16 @@
 import { createContext, useContext, useState, useEffect, useRef, useCallback } from 'react'
+import api from '../services/api'
 
 const WebSocketContext = createContext(null)
 
 const WS_URL = 'ws://localhost:8080/ws'
+// Version 2 sends one frame per WebSocket message
+const WS_PROTOCOL = 'chat.v2'
+// Tell the server the user is still here at most this often
+const ACTIVITY_INTERVAL = 60 * 1000
+
+// Presence frames identify users by user_id; the sidebar expects id
+const presenceUser = (presence) => ({ ...presence, id: presence.user_id })
 
 export function WebSocketProvider({ children }) {
   const [isConnected, setIsConnected] = useState(false)
@@ -12,17 +20,27 @@ export function WebSocketProvider({ children }) {
   const wsRef = useRef(null)
   const reconnectTimeoutRef = useRef(null)
   const messageHandlersRef = useRef([])
+  // Highest message ID seen per room, sent on reconnect to replay the gap
+  const lastSeenRef = useRef({})
 
   const connect = useCallback(() => {
     const token = localStorage.getItem('token')
     if (!token) return
 
     try {
-      wsRef.current = new WebSocket(`${WS_URL}?token=${token}`)
+      wsRef.current = new WebSocket(`${WS_URL}?token=${token}`, [WS_PROTOCOL])
 
       wsRef.current.onopen = () => {
         console.log('WebSocket connected')
         setIsConnected(true)
+
+        const rooms = Object.entries(lastSeenRef.current).map(([roomId, lastMessageId]) => ({
+          room_id: Number(roomId),
+          last_message_id: lastMessageId,
+        }))
+        if (rooms.length > 0) {
+          wsRef.current.send(JSON.stringify({ type: 'resume', payload: { rooms } }))
+        }
       }
 
       wsRef.current.onclose = () => {
@@ -52,22 +70,83 @@ export function WebSocketProvider({ children }) {
     }
   }, [])
 
+  const trackLastSeen = (roomId, roomMessages) => {
+    for (const m of roomMessages) {
+      if (m.id > (lastSeenRef.current[roomId] || 0)) {
+        lastSeenRef.current[roomId] = m.id
+      }
+    }
+  }
+
   const handleMessage = (data) => {
     switch (data.type) {
       case 'new_message':
         const message = data.payload
+        trackLastSeen(message.room_id, [message])
         setMessages(prev => ({
           ...prev,
-          [message.room_id]: [...(prev[message.room_id] || []), message]
+          [message.room_id]: [
+            ...(prev[message.room_id] || []).filter(m => m.id !== message.id),
+            message,
+          ]
         }))
         // Call registered handlers
         messageHandlersRef.current.forEach(handler => handler(message))
         break
 
+      case 'message_updated': {
+        const updated = data.payload
+        setMessages(prev => ({
+          ...prev,
+          [updated.room_id]: (prev[updated.room_id] || []).map(m => m.id === updated.id ? updated : m)
+        }))
+        break
+      }
+
+      case 'message_deleted': {
+        const { room_id, message_id } = data.payload
+        setMessages(prev => ({
+          ...prev,
+          [room_id]: (prev[room_id] || []).map(m =>
+            m.id === message_id ? { ...m, content: '', is_deleted: true } : m
+          )
+        }))
+        break
+      }
+
+      case 'resync_required': {
+        // Too much was missed to replay; reload the latest page instead
+        const roomId = data.payload.room_id
+        api.getRoomMessages(roomId)
+          .then(page => {
+            trackLastSeen(roomId, page.messages)
+            setMessages(prev => ({ ...prev, [roomId]: page.messages }))
+          })
+          .catch(error => console.error('Failed to resync room:', error))
+        break
+      }
+
+      case 'welcome':
+      case 'resume_complete':
+        break
+
       case 'online_users':
         setOnlineUsers(data.payload || [])
         break
 
+      case 'presence_snapshot':
+        setOnlineUsers((data.payload || []).map(presenceUser))
+        break
+
+      case 'presence': {
+        const user = presenceUser(data.payload)
+        setOnlineUsers(prev => {
+          const others = prev.filter(u => u.id !== user.id)
+          return user.status === 'offline' ? others : [...others, user]
+        })
+        break
+      }
+
       case 'user_joined':
         console.log(`${data.payload.username} joined room ${data.payload.room_id}`)
         break
@@ -86,6 +165,17 @@ export function WebSocketProvider({ children }) {
         }))
         break
 
+      case 'message_ack':
+        break
+
+      case 'message_error':
+        console.warn('Message not sent:', data.payload?.error)
+        break
+
+      case 'error':
+        console.warn('Server error:', data.payload?.message)
+        break
+
       default:
         console.log('Unknown message type:', data.type)
     }
@@ -110,6 +200,22 @@ export function WebSocketProvider({ children }) {
     }
   }, [])
 
+  // Report interaction so the server does not mark us away while reading
+  useEffect(() => {
+    let lastSent = 0
+    const onActivity = () => {
+      const now = Date.now()
+      if (now - lastSent >= ACTIVITY_INTERVAL) {
+        lastSent = now
+        sendMessage('activity')
+      }
+    }
+
+    const events = ['mousemove', 'keydown', 'click', 'touchstart']
+    events.forEach(e => window.addEventListener(e, onActivity, { passive: true }))
+    return () => events.forEach(e => window.removeEventListener(e, onActivity))
+  }, [sendMessage])
+
   const joinRoom = useCallback((roomId) => {
     sendMessage('join_room', { room_id: roomId })
   }, [sendMessage])
@@ -119,7 +225,11 @@ export function WebSocketProvider({ children }) {
   }, [sendMessage])
 
   const sendChatMessage = useCallback((roomId, content) => {
-    sendMessage('send_message', { room_id: roomId, content })
+    // The server dedupes on client_msg_id, so a resend after a dropped
+    // connection cannot post the message twice
+    const clientMsgId = crypto.randomUUID()
+    sendMessage('send_message', { room_id: roomId, content, client_msg_id: clientMsgId })
+    return clientMsgId
   }, [sendMessage])
 
   const sendTyping = useCallback((roomId, isTyping) => {
@@ -127,6 +237,7 @@ export function WebSocketProvider({ children }) {
   }, [sendMessage])
 
   const addMessageToRoom = useCallback((roomId, newMessages) => {
+    trackLastSeen(roomId, newMessages)
     setMessages(prev => ({
       ...prev,
       [roomId]: newMessages
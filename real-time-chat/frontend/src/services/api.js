@@ -9,7 +9,49 @@ class ApiService {
     return localStorage.getItem('token')
   }
 
-  async request(endpoint, options = {}) {
+  getRefreshToken() {
+    return localStorage.getItem('refreshToken')
+  }
+
+  setTokens({ token, refresh_token }) {
+    localStorage.setItem('token', token)
+    localStorage.setItem('refreshToken', refresh_token)
+  }
+
+  clearTokens() {
+    localStorage.removeItem('token')
+    localStorage.removeItem('refreshToken')
+  }
+
+  // Rotate the refresh token once, sharing the in-flight request between
+  // concurrent callers so the old token is never presented twice.
+  async refresh() {
+    if (!this.refreshPromise) {
+      this.refreshPromise = (async () => {
+        const refreshToken = this.getRefreshToken()
+        if (!refreshToken) return false
+
+        const response = await fetch(`${this.baseUrl}/token/refresh`, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ refresh_token: refreshToken }),
+        })
+
+        if (!response.ok) {
+          this.clearTokens()
+          return false
+        }
+
+        this.setTokens(await response.json())
+        return true
+      })().finally(() => {
+        this.refreshPromise = null
+      })
+    }
+    return this.refreshPromise
+  }
+
+  async request(endpoint, options = {}, retry = true) {
     const url = `${this.baseUrl}${endpoint}`
     const token = this.getToken()
 
@@ -27,6 +69,12 @@ class ApiService {
       headers,
     })
 
+    if (response.status === 401 && retry && this.getRefreshToken()) {
+      if (await this.refresh()) {
+        return this.request(endpoint, options, false)
+      }
+    }
+
     const data = await response.json()
 
     if (!response.ok) {
@@ -51,10 +99,28 @@ class ApiService {
     })
   }
 
+  async logout() {
+    return this.request('/logout', {
+      method: 'POST',
+    })
+  }
+
   async getCurrentUser() {
     return this.request('/me')
   }
 
+  // Presence: online, away, dnd or invisible, plus an optional custom status
+  async getStatus() {
+    return this.request('/me/status')
+  }
+
+  async setStatus(presence, text = '', emoji = '', expiresAt = null) {
+    return this.request('/me/status', {
+      method: 'PUT',
+      body: JSON.stringify({ presence, text, emoji, expires_at: expiresAt }),
+    })
+  }
+
   // Room endpoints
   async getRooms() {
     return this.request('/rooms')
@@ -91,8 +157,11 @@ class ApiService {
     return this.request(`/rooms/${roomId}/members`)
   }
 
-  async getRoomMessages(roomId, limit = 50, offset = 0) {
-    return this.request(`/rooms/${roomId}/messages?limit=${limit}&offset=${offset}`)
+  // cursor is one of { before_id }, { after_id } or { around_id }, usually
+  // taken from the prev/next fields of a previous page
+  async getRoomMessages(roomId, cursor = {}, limit = 50) {
+    const params = new URLSearchParams({ limit, ...cursor })
+    return this.request(`/rooms/${roomId}/messages?${params}`)
   }
 }
 